@@ -3,11 +3,13 @@ module github.com/lunagic/poseidon
 go 1.24
 
 require (
+	github.com/andybalholm/brotli v1.2.6
+	github.com/klauspost/compress v1.18.0
 	github.com/lunagic/environment-go v0.0.1
+	github.com/pelletier/go-toml/v2 v2.2.4
 	github.com/spf13/cobra v1.8.1
+	github.com/spf13/pflag v1.0.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-)
+require github.com/inconshreveable/mousetrap v1.1.0 // indirect
@@ -0,0 +1,294 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lunagic/environment-go/environment"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the configuration file. Its keys are the names of the flags,
+// in the plural for repeatable ones, and poseidon.schema.json describes it.
+type fileConfig struct {
+	Host                *string               `yaml:"host" toml:"host"`
+	Port                *int                  `yaml:"port" toml:"port"`
+	Root                *string               `yaml:"root" toml:"root"`
+	Preset              *string               `yaml:"preset" toml:"preset"`
+	Index               []string              `yaml:"index" toml:"index"`
+	NotFoundFile        *string               `yaml:"not-found-file" toml:"not-found-file"`
+	Nearest             *bool                 `yaml:"nearest" toml:"nearest"`
+	ClientSideRouting   *bool                 `yaml:"csr" toml:"csr"`
+	CachePolicy         *bool                 `yaml:"cache-policy" toml:"cache-policy"`
+	FingerprintPatterns []string              `yaml:"fingerprint-patterns" toml:"fingerprint-patterns"`
+	CacheRules          []fileCacheRule       `yaml:"cache-rules" toml:"cache-rules"`
+	NotFoundCache       *string               `yaml:"not-found-cache" toml:"not-found-cache"`
+	FallbackCache       *string               `yaml:"fallback-cache" toml:"fallback-cache"`
+	Compression         *[]string             `yaml:"compression" toml:"compression"`
+	Precompressed       *bool                 `yaml:"precompressed" toml:"precompressed"`
+	Redirects           *bool                 `yaml:"redirects" toml:"redirects"`
+	Headers             *bool                 `yaml:"headers" toml:"headers"`
+	RedirectRules       []fileRedirect        `yaml:"redirect-rules" toml:"redirect-rules"`
+	Proxies             map[string]string     `yaml:"proxies" toml:"proxies"`
+	ProxyTimeout        *string               `yaml:"proxy-timeout" toml:"proxy-timeout"`
+	ProxyCacheSize      *int                  `yaml:"proxy-cache-size" toml:"proxy-cache-size"`
+	ProxyCacheDir       *string               `yaml:"proxy-cache-dir" toml:"proxy-cache-dir"`
+	Listing             *bool                 `yaml:"listing" toml:"listing"`
+	ListingPaths        []string              `yaml:"listing-paths" toml:"listing-paths"`
+	CleanURLs           *bool                 `yaml:"clean-urls" toml:"clean-urls"`
+	CleanURLRedirects   *bool                 `yaml:"clean-url-redirects" toml:"clean-url-redirects"`
+	TrailingSlash       *string               `yaml:"trailing-slash" toml:"trailing-slash"`
+	HiddenFiles         *bool                 `yaml:"hidden-files" toml:"hidden-files"`
+	AllowHidden         *[]string             `yaml:"allow-hidden" toml:"allow-hidden"`
+	Deny                []string              `yaml:"deny" toml:"deny"`
+	Symlinks            *string               `yaml:"symlinks" toml:"symlinks"`
+	MIMETypes           map[string]string     `yaml:"mime-types" toml:"mime-types"`
+	ErrorPages          map[string]string     `yaml:"error-pages" toml:"error-pages"`
+	SecurityHeaders     *bool                 `yaml:"security-headers" toml:"security-headers"`
+	CSP                 *string               `yaml:"csp" toml:"csp"`
+	CSPReportOnly       *bool                 `yaml:"csp-report-only" toml:"csp-report-only"`
+	VHosts              map[string]fileConfig `yaml:"vhosts" toml:"vhosts"`
+}
+
+type fileRedirect struct {
+	From   string `yaml:"from" toml:"from"`
+	To     string `yaml:"to" toml:"to"`
+	Status int    `yaml:"status" toml:"status"`
+}
+
+type fileCacheRule struct {
+	Pattern    string `yaml:"pattern" toml:"pattern"`
+	Directives string `yaml:"directives" toml:"directives"`
+}
+
+// readConfigFile decodes a YAML, JSON or TOML file and refuses unknown keys.
+func readConfigFile(filePath string) (fileConfig, error) {
+	file := fileConfig{}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return file, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml", ".json":
+		// JSON is a subset of YAML, which reports line numbers
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return file, fmt.Errorf("%s: %w", filePath, err)
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&file); err != nil {
+			return file, fmt.Errorf("%s: %w", filePath, tomlError(err))
+		}
+	default:
+		return file, fmt.Errorf("%s: unsupported config file format, expected .yaml, .yml, .json or .toml", filePath)
+	}
+
+	return file, nil
+}
+
+func tomlError(err error) error {
+	var strictErr *toml.StrictMissingError
+	if errors.As(err, &strictErr) {
+		messages := []string{}
+		for _, keyErr := range strictErr.Errors {
+			row, _ := keyErr.Position()
+			messages = append(messages, fmt.Sprintf("line %d: unknown key %s", row, strings.Join(keyErr.Key(), ".")))
+		}
+
+		return errors.New(strings.Join(messages, "\n"))
+	}
+
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		row, _ := decodeErr.Position()
+
+		return fmt.Errorf("line %d: %w", row, err)
+	}
+
+	return err
+}
+
+// apply copies the values present in the file into the config and returns
+// the names of the flags they belong to.
+func (file fileConfig) apply(config *Config) (map[string]bool, error) {
+	settings := map[string]bool{}
+
+	setString := func(flag string, target *string, value *string) {
+		if value != nil {
+			*target = *value
+			settings[flag] = true
+		}
+	}
+	setBool := func(flag string, target *bool, value *bool) {
+		if value != nil {
+			*target = *value
+			settings[flag] = true
+		}
+	}
+	setList := func(flag string, target *string, value []string, separator string) {
+		if value != nil {
+			*target = strings.Join(value, separator)
+			settings[flag] = true
+		}
+	}
+	setPairs := func(flag string, target *string, value map[string]string) {
+		if value == nil {
+			return
+		}
+
+		pairs := []string{}
+		for key, pairValue := range value {
+			pairs = append(pairs, key+"="+pairValue)
+		}
+		slices.Sort(pairs)
+		*target = strings.Join(pairs, ",")
+		settings[flag] = true
+	}
+
+	if file.Port != nil {
+		config.Port = *file.Port
+		settings["port"] = true
+	}
+
+	if file.ProxyCacheSize != nil {
+		config.ProxyCacheSize = *file.ProxyCacheSize
+		settings["proxy-cache-size"] = true
+	}
+
+	setString("host", &config.Host, file.Host)
+	setString("root", &config.Root, file.Root)
+	setString("preset", &config.Preset, file.Preset)
+	setList("index", &config.Index, file.Index, ",")
+	setString("not-found-file", &config.NotFoundFile, file.NotFoundFile)
+	setBool("nearest", &config.Nearest, file.Nearest)
+	setBool("csr", &config.ClientSideRouting, file.ClientSideRouting)
+	setBool("cache-policy", &config.CachePolicy, file.CachePolicy)
+	setList("fingerprint-pattern", &config.FingerprintPatterns, file.FingerprintPatterns, " ")
+	setString("not-found-cache", &config.NotFoundCache, file.NotFoundCache)
+	setString("fallback-cache", &config.FallbackCache, file.FallbackCache)
+	setBool("precompressed", &config.Precompressed, file.Precompressed)
+	setBool("redirects", &config.Redirects, file.Redirects)
+	setBool("headers", &config.Headers, file.Headers)
+	setPairs("proxy", &config.Proxies, file.Proxies)
+	setString("proxy-timeout", &config.ProxyTimeout, file.ProxyTimeout)
+	setString("proxy-cache-dir", &config.ProxyCacheDir, file.ProxyCacheDir)
+	setBool("listing", &config.Listing, file.Listing)
+	setList("listing-paths", &config.ListingPaths, file.ListingPaths, ",")
+	setBool("clean-urls", &config.CleanURLs, file.CleanURLs)
+	setBool("clean-url-redirects", &config.CleanURLRedirects, file.CleanURLRedirects)
+	setString("trailing-slash", &config.TrailingSlash, file.TrailingSlash)
+	setBool("hidden-files", &config.HiddenFiles, file.HiddenFiles)
+	setList("deny", &config.Deny, file.Deny, ",")
+	setString("symlinks", &config.Symlinks, file.Symlinks)
+	setPairs("mime-types", &config.MIMETypes, file.MIMETypes)
+	setPairs("error-page", &config.ErrorPages, file.ErrorPages)
+	setBool("security-headers", &config.SecurityHeaders, file.SecurityHeaders)
+	setString("csp", &config.CSP, file.CSP)
+	setBool("csp-report-only", &config.CSPReportOnly, file.CSPReportOnly)
+
+	if file.Compression != nil {
+		config.Compression = strings.Join(*file.Compression, ",")
+		if len(*file.Compression) == 0 {
+			config.Compression = "none"
+		}
+		settings["compression"] = true
+	}
+
+	if file.AllowHidden != nil {
+		config.AllowHidden = strings.Join(*file.AllowHidden, ",")
+		settings["allow-hidden"] = true
+	}
+
+	if file.VHosts != nil {
+		config.fileVHosts = file.VHosts
+		settings["vhost"] = true
+	}
+
+	if file.CacheRules != nil {
+		rules := []string{}
+		for i, rule := range file.CacheRules {
+			if rule.Pattern == "" {
+				return nil, fmt.Errorf("cache rule %d: missing pattern", i+1)
+			}
+
+			rules = append(rules, rule.Pattern+"="+rule.Directives)
+		}
+		config.CacheRules = strings.Join(rules, ";")
+		settings["cache-rule"] = true
+	}
+
+	if file.RedirectRules != nil {
+		rules := []string{}
+		for i, rule := range file.RedirectRules {
+			if rule.From == "" || rule.To == "" {
+				return nil, fmt.Errorf("redirect rule %d: missing from or to", i+1)
+			}
+
+			status := rule.Status
+			if status == 0 {
+				status = http.StatusMovedPermanently
+			}
+			rules = append(rules, fmt.Sprintf("%s %s %d", rule.From, rule.To, status))
+		}
+		config.RedirectRules = strings.Join(rules, ";")
+		settings["redirect"] = true
+	}
+
+	return settings, nil
+}
+
+// loadConfigFile rebuilds the config from the defaults, the file, the env
+// vars and the flags, in increasing order of precedence.
+func (config *Config) loadConfigFile(cmd *cobra.Command) error {
+	file, err := readConfigFile(config.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	// The flags already wrote into the config, remember what they said
+	changed := map[string]string{}
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		changed[flag.Name] = flag.Value.String()
+	})
+
+	configFile := config.ConfigFile
+	*config = *defaultConfig()
+	config.ConfigFile = configFile
+
+	config.fileSettings, err = file.apply(config)
+	if err != nil {
+		return fmt.Errorf("%s: %w", configFile, err)
+	}
+
+	if err := environment.New().Decode(config); err != nil {
+		return err
+	}
+
+	for name, value := range changed {
+		flag := cmd.Flags().Lookup(name)
+		if list, ok := flag.Value.(*listValue); ok {
+			list.changed = false
+		}
+
+		if err := flag.Value.Set(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filePath
+}
+
+func TestReadConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		"poseidon.yaml": "root: dist\ncsr: true\ncompression: [br, gzip]\nerror-pages:\n  500: 500.html\n",
+		"poseidon.json": `{"root": "dist", "csr": true, "compression": ["br", "gzip"], "error-pages": {"500": "500.html"}}`,
+		"poseidon.toml": "root = \"dist\"\ncsr = true\ncompression = [\"br\", \"gzip\"]\n[error-pages]\n500 = \"500.html\"\n",
+	}
+
+	for name, content := range files {
+		file, err := readConfigFile(writeConfigFile(t, name, content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := defaultConfig()
+		settings, err := file.apply(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.Root != "dist" || !config.ClientSideRouting || config.Compression != "br,gzip" || config.ErrorPages != "500=500.html" {
+			t.Fatalf("Unexpected config from %s: %+v", name, config)
+		}
+
+		if !settings["root"] || !settings["csr"] || !settings["error-page"] || settings["port"] {
+			t.Fatalf("Unexpected settings from %s: %v", name, settings)
+		}
+	}
+}
+
+func TestReadConfigFileUnknownKey(t *testing.T) {
+	files := map[string]string{
+		"poseidon.yaml": "root: dist\nrots: dist\n",
+		"poseidon.toml": "root = \"dist\"\nrots = \"dist\"\n",
+	}
+
+	for name, content := range files {
+		_, err := readConfigFile(writeConfigFile(t, name, content))
+		if err == nil || !strings.Contains(err.Error(), "rots") {
+			t.Fatalf("Expected an error naming the unknown key in %s, Got: %v", name, err)
+		}
+	}
+}
+
+func TestReadConfigFileUnsupportedFormat(t *testing.T) {
+	if _, err := readConfigFile(writeConfigFile(t, "poseidon.ini", "root=dist\n")); err == nil {
+		t.Fatal("Expected an error for an unsupported format")
+	}
+}
+
+func TestLoadConfigFilePrecedence(t *testing.T) {
+	cmd, config := testCommand(t)
+
+	config.ConfigFile = writeConfigFile(t, "poseidon.yaml", strings.Join([]string{
+		"root: from-file",
+		"port: 4000",
+		"index: [index.html, index.htm]",
+		"deny: [\"*.map\"]",
+	}, "\n"))
+
+	// Flags are parsed into the config before the file is read
+	if err := cmd.Flags().Set("port", "5000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Flags().Set("deny", "*.log"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("POSEIDON_ROOT", "from-env")
+
+	if err := config.loadConfigFile(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Root != "from-env" {
+		t.Fatalf("Expected the env var to win over the file, Got: %s", config.Root)
+	}
+
+	if config.Port != 5000 {
+		t.Fatalf("Expected the flag to win over the file, Got: %d", config.Port)
+	}
+
+	if config.Deny != "*.log" {
+		t.Fatalf("Expected the list flag to replace the file, Got: %s", config.Deny)
+	}
+
+	if config.Index != "index.html,index.htm" {
+		t.Fatalf("Expected the file to win over the default, Got: %s", config.Index)
+	}
+
+	if config.Host != defaultConfig().Host {
+		t.Fatalf("Expected the default, Got: %s", config.Host)
+	}
+}
+
+func TestLoadConfigFileInvalidRule(t *testing.T) {
+	cmd, config := testCommand(t)
+	config.ConfigFile = writeConfigFile(t, "poseidon.yaml", "redirect-rules:\n  - from: /old\n")
+
+	if err := config.loadConfigFile(cmd); err == nil {
+		t.Fatal("Expected an error for a redirect rule without a target")
+	}
+}
@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const presetAuto = "auto"
+
+const (
+	immutableDirectives  = "max-age=31536000, immutable"
+	revalidateDirectives = "no-cache"
+)
+
+// preset holds the conventions of a framework's build output.
+type preset struct {
+	name string
+	// markers all have to exist in the root for auto detection
+	markers []string
+	// immutable path prefixes only contain fingerprinted files
+	immutable []string
+	// revalidate path prefixes inside immutable ones that do change
+	revalidate []string
+	// notFoundFiles are tried in order, the first one in the root is used
+	notFoundFiles []string
+	// appShell is the client side routing fallback, when the build has one
+	appShell          string
+	cleanURLs         bool
+	clientSideRouting bool
+	// nearest serves the 404 page of the section, such as a language
+	nearest bool
+}
+
+// presets are tried in order during auto detection, the more specific first
+var presets = []preset{
+	{
+		name:          "next",
+		markers:       []string{"_next/static"},
+		immutable:     []string{"/_next/static/"},
+		notFoundFiles: []string{"404.html"},
+		cleanURLs:     true,
+	},
+	{
+		name:          "astro",
+		markers:       []string{"_astro"},
+		immutable:     []string{"/_astro/"},
+		notFoundFiles: []string{"404.html"},
+		cleanURLs:     true,
+	},
+	{
+		name:          "sveltekit",
+		markers:       []string{"_app/immutable"},
+		immutable:     []string{"/_app/immutable/"},
+		notFoundFiles: []string{"404.html"},
+		// The SPA fallback of adapter-static
+		appShell:  "200.html",
+		cleanURLs: true,
+	},
+	{
+		name:          "nuxt",
+		markers:       []string{"_nuxt"},
+		immutable:     []string{"/_nuxt/"},
+		revalidate:    []string{"/_nuxt/builds/"},
+		notFoundFiles: []string{"404.html"},
+		// nuxi generate writes the SPA fallback next to the 404 page
+		appShell: "200.html",
+	},
+	{
+		name:          "gatsby",
+		markers:       []string{"page-data"},
+		immutable:     []string{"/static/"},
+		revalidate:    []string{"/page-data/"},
+		notFoundFiles: []string{"404.html"},
+	},
+	{
+		name:          "docusaurus",
+		markers:       []string{"assets/js/runtime~main.*.js"},
+		immutable:     []string{"/assets/"},
+		notFoundFiles: []string{"404.html"},
+		nearest:       true,
+	},
+	{
+		name:              "vite",
+		markers:           []string{".vite/manifest.json"},
+		immutable:         []string{"/assets/"},
+		notFoundFiles:     []string{"404.html"},
+		clientSideRouting: true,
+	},
+	{
+		name:          "hugo",
+		markers:       []string{"index.xml", "sitemap.xml"},
+		notFoundFiles: []string{"404.html"},
+		// Multilingual sites have a 404 page per language
+		nearest: true,
+	},
+}
+
+func presetNames() []string {
+	names := []string{presetAuto}
+	for _, preset := range presets {
+		names = append(names, preset.name)
+	}
+
+	return names
+}
+
+// findPreset looks the preset up by name or detects it from the root. Auto
+// detection finding nothing isn't an error.
+func findPreset(name string, root fs.FS) (*preset, error) {
+	for i, preset := range presets {
+		if name == preset.name || (name == presetAuto && preset.detect(root)) {
+			return &presets[i], nil
+		}
+	}
+
+	if name == presetAuto {
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown preset %s, expected one of %s", name, strings.Join(presetNames(), ", "))
+}
+
+func (preset preset) detect(root fs.FS) bool {
+	for _, marker := range preset.markers {
+		matches, err := fs.Glob(root, marker)
+		if err != nil || len(matches) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apply changes the settings the user didn't choose explicitly through a flag
+// or an env var.
+func (preset preset) apply(cmd *cobra.Command, config *Config, root fs.FS) {
+	explicit := func(flag string, env string) bool {
+		_, found := os.LookupEnv(env)
+
+		return found || cmd.Flags().Changed(flag) || config.fileSettings[flag]
+	}
+
+	if preset.cleanURLs && !explicit("clean-urls", "POSEIDON_CLEAN_URLS") {
+		config.CleanURLs = true
+	}
+
+	if !explicit("csr", "POSEIDON_CLIENT_SIDE_ROUTING") {
+		if preset.clientSideRouting {
+			config.ClientSideRouting = true
+		}
+
+		if stat, err := fs.Stat(root, preset.appShell); preset.appShell != "" && err == nil && !stat.IsDir() {
+			config.ClientSideRouting = true
+			config.appShell = preset.appShell
+		}
+	}
+
+	if preset.nearest && !explicit("nearest", "POSEIDON_NEAREST") {
+		config.Nearest = true
+	}
+
+	if !explicit("not-found-file", "POSEIDON_NOT_FOUND_FILE") {
+		for _, notFoundFile := range preset.notFoundFiles {
+			if stat, err := fs.Stat(root, notFoundFile); err == nil && !stat.IsDir() {
+				config.NotFoundFile = notFoundFile
+				break
+			}
+		}
+	}
+
+	if !config.CachePolicy {
+		return
+	}
+
+	// Rules given by the user come first so they win
+	rules := []string{}
+	if config.CacheRules != "" {
+		rules = append(rules, config.CacheRules)
+	}
+	for _, prefix := range preset.revalidate {
+		rules = append(rules, prefix+"="+revalidateDirectives)
+	}
+	for _, prefix := range preset.immutable {
+		rules = append(rules, prefix+"="+immutableDirectives)
+	}
+	config.CacheRules = strings.Join(rules, ";")
+}
+
+// logSettings prints the value of every flag, including the changes made by
+// presets.
+func logSettings(cmd *cobra.Command) {
+	settings := []string{}
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "help" {
+			return
+		}
+
+		settings = append(settings, fmt.Sprintf("--%s=%q", flag.Name, flag.Value.String()))
+	})
+
+	log.Printf("Settings: %s", strings.Join(settings, " "))
+}
@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/lunagic/poseidon/poseidon"
+	"github.com/spf13/cobra"
+)
+
+func testCommand(t *testing.T) (*cobra.Command, *Config) {
+	t.Helper()
+
+	config := defaultConfig()
+	cmd := &cobra.Command{}
+	config.AddFlags(cmd)
+
+	return cmd, config
+}
+
+func TestFindPresetAuto(t *testing.T) {
+	testCases := map[string]fstest.MapFS{
+		"next": {
+			"_next/static/chunks/main.js": {},
+			"_next/data/build.json":       {},
+		},
+		"sveltekit": {
+			"_app/immutable/entry/start.js": {},
+		},
+		"docusaurus": {
+			"assets/js/runtime~main.3e1f2a7c.js": {},
+		},
+		"vite": {
+			".vite/manifest.json":      {},
+			"assets/index-BxWq3F2a.js": {},
+		},
+		"hugo": {
+			"index.xml":   {},
+			"sitemap.xml": {},
+		},
+	}
+
+	for expected, root := range testCases {
+		preset, err := findPreset(presetAuto, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if preset == nil || preset.name != expected {
+			t.Fatalf("Unexpected preset, Got: %v, Expected: %s", preset, expected)
+		}
+	}
+}
+
+func TestFindPresetNothingDetected(t *testing.T) {
+	preset, err := findPreset(presetAuto, fstest.MapFS{
+		"index.xml": {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if preset != nil {
+		t.Fatalf("Unexpected preset: %s", preset.name)
+	}
+}
+
+func TestFindPresetUnknown(t *testing.T) {
+	if _, err := findPreset("jekyll", fstest.MapFS{}); err == nil {
+		t.Fatal("Expected an error for an unknown preset")
+	}
+}
+
+func TestPresetApply(t *testing.T) {
+	cmd, config := testCommand(t)
+
+	preset, err := findPreset("sveltekit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preset.apply(cmd, config, fstest.MapFS{
+		"200.html": {},
+		"404.html": {},
+	})
+
+	if !config.CleanURLs {
+		t.Fatal("Expected clean URLs")
+	}
+
+	if config.NotFoundFile != "404.html" {
+		t.Fatalf("Unexpected not found file: %s", config.NotFoundFile)
+	}
+
+	if !config.ClientSideRouting || config.appShell != "200.html" {
+		t.Fatalf("Expected 200.html as the app shell, Got: %q", config.appShell)
+	}
+
+	if config.CacheRules != "/_app/immutable/="+immutableDirectives {
+		t.Fatalf("Unexpected cache rules: %s", config.CacheRules)
+	}
+}
+
+func TestPresetApplyMissingNotFoundFile(t *testing.T) {
+	cmd, config := testCommand(t)
+
+	preset, err := findPreset("nuxt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preset.apply(cmd, config, fstest.MapFS{
+		"404.html": {},
+	})
+
+	if config.NotFoundFile != "404.html" {
+		t.Fatalf("Unexpected not found file: %s", config.NotFoundFile)
+	}
+
+	if config.ClientSideRouting {
+		t.Fatal("Expected no client side routing without an app shell")
+	}
+}
+
+func TestPresetAppShell(t *testing.T) {
+	directory := t.TempDir()
+	for name, content := range map[string]string{
+		"200.html": "shell",
+		"404.html": "not found",
+	} {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd, config := testCommand(t)
+
+	preset, err := findPreset("sveltekit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := poseidon.DirFS(directory)
+	preset.apply(cmd, config, root)
+
+	service, err := config.newService(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+	request.Header.Set("Accept", "text/html")
+
+	recorder := httptest.NewRecorder()
+	service.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "shell" {
+		t.Fatalf("Unexpected response, Got: %d %q, Expected: %d %q", recorder.Code, recorder.Body.String(), http.StatusOK, "shell")
+	}
+}
+
+func TestPresetApplyKeepsExplicitSettings(t *testing.T) {
+	cmd, config := testCommand(t)
+
+	if err := cmd.Flags().Set("clean-urls", "false"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("POSEIDON_NOT_FOUND_FILE", "missing.html")
+	config.NotFoundFile = "missing.html"
+	if err := cmd.Flags().Set("cache-rule", "/_app/=no-store"); err != nil {
+		t.Fatal(err)
+	}
+
+	preset, err := findPreset("sveltekit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preset.apply(cmd, config, fstest.MapFS{
+		"200.html": {},
+	})
+
+	if config.CleanURLs {
+		t.Fatal("Expected the flag to win over the preset")
+	}
+
+	if config.NotFoundFile != "missing.html" {
+		t.Fatalf("Expected the env var to win over the preset, Got: %s", config.NotFoundFile)
+	}
+
+	// Rules of the user come first so they match first
+	if config.CacheRules != "/_app/=no-store;/_app/immutable/="+immutableDirectives {
+		t.Fatalf("Unexpected cache rules: %s", config.CacheRules)
+	}
+}
+
+func TestPresetApplyFileSettings(t *testing.T) {
+	cmd, config := testCommand(t)
+	config.fileSettings = map[string]bool{"nearest": true}
+
+	preset, err := findPreset("hugo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preset.apply(cmd, config, fstest.MapFS{})
+
+	if config.Nearest {
+		t.Fatal("Expected the config file to win over the preset")
+	}
+}
@@ -2,10 +2,12 @@ package cli
 
 import (
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lunagic/environment-go/environment"
 	"github.com/lunagic/poseidon/poseidon"
@@ -19,14 +21,80 @@ type Config struct {
 	ClientSideRouting bool   `env:"POSEIDON_CLIENT_SIDE_ROUTING"`
 	Index             string `env:"POSEIDON_INDEX"`
 	NotFoundFile      string `env:"POSEIDON_NOT_FOUND_FILE"`
+	Nearest           bool   `env:"POSEIDON_NEAREST"`
 	CachePolicy       bool   `env:"POSEIDON_CACHE_POLICY"`
-	GZIP              bool   `env:"POSEIDON_GZIP"`
+	Compression       string `env:"POSEIDON_COMPRESSION"`
+	Precompressed     bool   `env:"POSEIDON_PRECOMPRESSED"`
+	Redirects         bool   `env:"POSEIDON_REDIRECTS"`
+	Headers           bool   `env:"POSEIDON_HEADERS"`
+	// Redirect rules are semicolon separated "from to [status]" triples
+	RedirectRules     string `env:"POSEIDON_REDIRECT_RULES"`
+	Proxies           string `env:"POSEIDON_PROXIES"`
+	ProxyTimeout      string `env:"POSEIDON_PROXY_TIMEOUT"`
+	ProxyCacheSize    int    `env:"POSEIDON_PROXY_CACHE_SIZE"`
+	ProxyCacheDir     string `env:"POSEIDON_PROXY_CACHE_DIR"`
+	Listing           bool   `env:"POSEIDON_LISTING"`
+	ListingPaths      string `env:"POSEIDON_LISTING_PATHS"`
+	CleanURLs         bool   `env:"POSEIDON_CLEAN_URLS"`
+	CleanURLRedirects bool   `env:"POSEIDON_CLEAN_URL_REDIRECTS"`
+	TrailingSlash     string `env:"POSEIDON_TRAILING_SLASH"`
+	HiddenFiles       bool   `env:"POSEIDON_HIDDEN_FILES"`
+	AllowHidden       string `env:"POSEIDON_ALLOW_HIDDEN"`
+	Deny              string `env:"POSEIDON_DENY"`
+	Symlinks          string `env:"POSEIDON_SYMLINKS"`
+	MIMETypes         string `env:"POSEIDON_MIME_TYPES"`
+	ErrorPages        string `env:"POSEIDON_ERROR_PAGES"`
+	// Regular expressions may contain commas so they are space separated
+	FingerprintPatterns string `env:"POSEIDON_FINGERPRINT_PATTERNS"`
+	// Cache directives contain commas so rules are semicolon separated
+	CacheRules    string `env:"POSEIDON_CACHE_RULES"`
+	NotFoundCache string `env:"POSEIDON_NOT_FOUND_CACHE"`
+	FallbackCache string `env:"POSEIDON_FALLBACK_CACHE"`
+	Preset        string `env:"POSEIDON_PRESET"`
+	ConfigFile    string `env:"POSEIDON_CONFIG"`
+	// Virtual hosts are comma separated host=root pairs
+	VHosts          string `env:"POSEIDON_VHOSTS"`
+	SecurityHeaders bool   `env:"POSEIDON_SECURITY_HEADERS"`
+	CSP             string `env:"POSEIDON_CSP"`
+	CSPReportOnly   bool   `env:"POSEIDON_CSP_REPORT_ONLY"`
+
+	// fileSettings are the flags the config file set
+	fileSettings map[string]bool
+	// fileVHosts are the virtual hosts of the config file with their settings
+	fileVHosts map[string]fileConfig
+	// proxyCache is shared by all sites
+	proxyCache *poseidon.ProxyCache
+	// appShell is served for client side routes instead of the index
+	appShell string
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Host:         "127.0.0.1",
+		Port:         3000,
+		NotFoundFile: "404.html",
+		Index:        "index.html",
+		Root:         ".",
+		Compression:  "br,zstd,gzip",
+		CachePolicy:  true,
+		AllowHidden:  ".well-known/",
+		Symlinks:     string(poseidon.SymlinkWithinRoot),
+		ProxyTimeout: "30s",
+	}
 }
 
 func (config *Config) ListenAddress() string {
 	return fmt.Sprintf("%s:%d", config.Host, config.Port)
 }
 
+func (config *Config) CompressionEncodings() []string {
+	if config.Compression == "none" {
+		return nil
+	}
+
+	return splitList(config.Compression)
+}
+
 func (config *Config) AddFlags(cmd *cobra.Command) {
 	cmd.Flags().IntVarP(
 		&config.Port,
@@ -58,11 +126,79 @@ func (config *Config) AddFlags(cmd *cobra.Command) {
 		"enables Client Side Routing (serves the index in a \"not found\" situation)",
 	)
 
+	cmd.Flags().StringVar(
+		&config.Compression,
+		"compression",
+		config.Compression,
+		"comma separated list of encodings to compress with in order of preference (br, zstd, gzip) or \"none\"",
+	)
+
+	cmd.Flags().BoolVar(
+		&config.Precompressed,
+		"precompressed",
+		config.Precompressed,
+		"serves precompressed sibling files (.br, .zst, .gz) when the client accepts them",
+	)
+
+	cmd.Flags().BoolVar(
+		&config.Redirects,
+		"redirects",
+		config.Redirects,
+		"applies the rules of a Netlify style _redirects file in the root",
+	)
+
 	cmd.Flags().BoolVar(
-		&config.GZIP,
-		"gzip",
-		config.GZIP,
-		"enables gzip compression",
+		&config.Headers,
+		"headers",
+		config.Headers,
+		"attaches the headers of a Netlify or Cloudflare style _headers file in the root",
+	)
+
+	cmd.Flags().Var(
+		newListValue(&config.RedirectRules, ";"),
+		"redirect",
+		"redirects a pattern to a target (e.g. \"/old/{path...} /new/{path} 301\"), status 200 rewrites, can be repeated",
+	)
+
+	cmd.Flags().Var(
+		newListValue(&config.Proxies, ","),
+		"proxy",
+		"forwards a path prefix to an upstream (e.g. \"/api=http://127.0.0.1:8080\"), an upstream path replaces the prefix, can be repeated",
+	)
+
+	cmd.Flags().StringVar(
+		&config.ProxyTimeout,
+		"proxy-timeout",
+		config.ProxyTimeout,
+		"how long upstreams can take to respond before a 504 (e.g. \"10s\")",
+	)
+
+	cmd.Flags().IntVar(
+		&config.ProxyCacheSize,
+		"proxy-cache-size",
+		config.ProxyCacheSize,
+		"megabytes of upstream responses to cache, 0 disables the proxy cache",
+	)
+
+	cmd.Flags().StringVar(
+		&config.ProxyCacheDir,
+		"proxy-cache-dir",
+		config.ProxyCacheDir,
+		"directory to keep cached upstream responses in instead of memory",
+	)
+
+	cmd.Flags().BoolVar(
+		&config.Listing,
+		"listing",
+		config.Listing,
+		"enables directory listings for directories without an index",
+	)
+
+	cmd.Flags().StringVar(
+		&config.ListingPaths,
+		"listing-paths",
+		config.ListingPaths,
+		"comma separated list of path prefixes to restrict directory listings to",
 	)
 
 	cmd.Flags().BoolVar(
@@ -79,25 +215,152 @@ func (config *Config) AddFlags(cmd *cobra.Command) {
 		"file to serve with the 404 status code",
 	)
 
+	cmd.Flags().BoolVar(
+		&config.Nearest,
+		"nearest",
+		config.Nearest,
+		"looks up the not found file and the Client Side Routing index in the closest parent directory first",
+	)
+
 	cmd.Flags().StringVar(
 		&config.Index,
 		"index",
 		config.Index,
-		"the index file to use",
+		"comma separated list of index files to try in a directory",
+	)
+
+	cmd.Flags().BoolVar(
+		&config.CleanURLs,
+		"clean-urls",
+		config.CleanURLs,
+		"serves \"about.html\" for \"/about\"",
+	)
+
+	cmd.Flags().BoolVar(
+		&config.CleanURLRedirects,
+		"clean-url-redirects",
+		config.CleanURLRedirects,
+		"enables clean URLs and redirects \"/about.html\" to \"/about\"",
+	)
+
+	cmd.Flags().StringVar(
+		&config.TrailingSlash,
+		"trailing-slash",
+		config.TrailingSlash,
+		"trailing slash policy (add, strip or ignore) using permanent redirects",
+	)
+
+	cmd.Flags().BoolVar(
+		&config.HiddenFiles,
+		"hidden-files",
+		config.HiddenFiles,
+		"serves dotfiles and dot-directories",
+	)
+
+	cmd.Flags().StringVar(
+		&config.AllowHidden,
+		"allow-hidden",
+		config.AllowHidden,
+		"comma separated list of hidden paths that are served anyway",
+	)
+
+	cmd.Flags().StringVar(
+		&config.Deny,
+		"deny",
+		config.Deny,
+		"comma separated list of glob patterns of paths to refuse",
+	)
+
+	cmd.Flags().StringVar(
+		&config.Symlinks,
+		"symlinks",
+		config.Symlinks,
+		"symlink policy (follow, within-root or deny)",
+	)
+
+	cmd.Flags().StringVar(
+		&config.MIMETypes,
+		"mime-types",
+		config.MIMETypes,
+		"comma separated list of extension to content type overrides (e.g. \".glb=model/gltf-binary\")",
+	)
+
+	cmd.Flags().Var(
+		newListValue(&config.ErrorPages, ","),
+		"error-page",
+		"file to serve for an error status (e.g. \"500=500.html\"), can be repeated",
+	)
+
+	cmd.Flags().Var(
+		newListValue(&config.FingerprintPatterns, " "),
+		"fingerprint-pattern",
+		"regular expression for paths of files that never change, replaces the built-in ones, can be repeated",
+	)
+
+	cmd.Flags().Var(
+		newListValue(&config.VHosts, ","),
+		"vhost",
+		"serves a host from its own root (e.g. \"example.com=/srv/example\"), where \"*.example.com=/srv/*\" serves each subdomain from the directory named after it, can be repeated",
+	)
+
+	cmd.Flags().BoolVar(
+		&config.SecurityHeaders,
+		"security-headers",
+		config.SecurityHeaders,
+		"sets HSTS, X-Frame-Options, Referrer-Policy, Permissions-Policy, cross-origin policies and a same origin Content-Security-Policy",
+	)
+
+	cmd.Flags().StringVar(
+		&config.CSP,
+		"csp",
+		config.CSP,
+		"Content-Security-Policy directives replacing the one of --security-headers (e.g. \"default-src 'self'; img-src *\")",
+	)
+
+	cmd.Flags().BoolVar(
+		&config.CSPReportOnly,
+		"csp-report-only",
+		config.CSPReportOnly,
+		"sends the Content-Security-Policy as Content-Security-Policy-Report-Only",
+	)
+
+	cmd.Flags().StringVar(
+		&config.ConfigFile,
+		"config",
+		config.ConfigFile,
+		"YAML, JSON or TOML file with settings, overridden by env vars and flags",
+	)
+
+	cmd.Flags().StringVar(
+		&config.Preset,
+		"preset",
+		config.Preset,
+		fmt.Sprintf("framework conventions to apply (one of %s)", strings.Join(presetNames(), ", ")),
+	)
+
+	cmd.Flags().Var(
+		newListValue(&config.CacheRules, ";"),
+		"cache-rule",
+		"cache directives for files matching a pattern (e.g. \"*.html=max-age=60, stale-while-revalidate=600\"), can be repeated",
+	)
+
+	cmd.Flags().StringVar(
+		&config.NotFoundCache,
+		"not-found-cache",
+		config.NotFoundCache,
+		"cache directives for 404 responses (e.g. \"max-age=10\")",
+	)
+
+	cmd.Flags().StringVar(
+		&config.FallbackCache,
+		"fallback-cache",
+		config.FallbackCache,
+		"cache directives for the index served by Client Side Routing",
 	)
 }
 
 func Cmd() *cobra.Command {
-	// Default configs
-	config := &Config{
-		Host:         "127.0.0.1",
-		Port:         3000,
-		NotFoundFile: "404.html",
-		Index:        "index.html",
-		Root:         ".",
-		GZIP:         true,
-		CachePolicy:  true,
-	}
+	config := defaultConfig()
 	if err := environment.New().Decode(config); err != nil {
 		panic(err)
 	}
@@ -105,40 +368,39 @@ func Cmd() *cobra.Command {
 	root := &cobra.Command{
 		Use: "poseidon",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fileSystem := os.DirFS(config.Root)
-
-			configFuncs := []poseidon.ConfigFunc{}
-
-			if config.CachePolicy {
-				configFuncs = append(configFuncs, poseidon.WithCachePolicy(
-					// Generic Generated Assets
-					func(path string) bool {
-						return strings.HasPrefix(path, "/_assets/")
-					},
-					// Next.js
-					func(path string) bool {
-						return strings.HasPrefix(path, "/_next/")
-					},
-				))
+			if config.ConfigFile != "" {
+				if err := config.loadConfigFile(cmd); err != nil {
+					return err
+				}
 			}
 
-			if config.Index != "" {
-				configFuncs = append(configFuncs, poseidon.WithCustomIndex(config.Index))
-			}
+			fileSystem := poseidon.DirFS(config.Root)
+
+			if config.Preset != "" {
+				preset, err := findPreset(config.Preset, fileSystem)
+				if err != nil {
+					return err
+				}
 
-			if config.NotFoundFile != "" {
-				configFuncs = append(configFuncs, poseidon.WithCustomNotFoundFile(config.NotFoundFile))
+				if preset != nil {
+					log.Printf("Using the %s preset", preset.name)
+					preset.apply(cmd, config, fileSystem)
+				} else {
+					log.Printf("No preset detected in %s", config.Root)
+				}
 			}
+			logSettings(cmd)
 
-			if config.ClientSideRouting {
-				configFuncs = append(configFuncs, poseidon.WithClientSideRouting())
+			if err := config.setupProxyCache(); err != nil {
+				return err
 			}
 
-			if config.GZIP {
-				configFuncs = append(configFuncs, poseidon.WithGZipCompression())
+			service, err := config.newService(fileSystem)
+			if err != nil {
+				return err
 			}
 
-			service, err := poseidon.New(fileSystem, configFuncs...)
+			handler, err := config.hostRouter(service)
 			if err != nil {
 				return err
 			}
@@ -147,7 +409,7 @@ func Cmd() *cobra.Command {
 				Addr: config.ListenAddress(),
 				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 					log.Printf("Request: %s", r.URL.Path)
-					service.ServeHTTP(w, r)
+					handler.ServeHTTP(w, r)
 				}),
 			}
 
@@ -160,3 +422,230 @@ func Cmd() *cobra.Command {
 
 	return root
 }
+
+// newService builds the service for a site from the settings.
+func (config *Config) newService(fileSystem fs.FS) (*poseidon.Service, error) {
+	configFuncs := []poseidon.ConfigFunc{
+		poseidon.WithSymlinkPolicy(poseidon.SymlinkPolicy(config.Symlinks)),
+	}
+
+	if config.SecurityHeaders || config.CSP != "" {
+		headers := poseidon.SecurityHeaders{}
+		if config.SecurityHeaders {
+			headers = poseidon.DefaultSecurityHeaders()
+		}
+
+		if config.CSP != "" {
+			policy, err := poseidon.ParseContentSecurityPolicy(config.CSP)
+			if err != nil {
+				return nil, err
+			}
+			headers.ContentSecurityPolicy = &policy
+		}
+		headers.ReportOnly = config.CSPReportOnly
+
+		configFuncs = append(configFuncs, poseidon.WithSecurityHeaders(headers))
+	}
+
+	if config.CachePolicy {
+		configFuncs = append(configFuncs, poseidon.WithCachePolicy(
+			// Generic Generated Assets
+			func(path string) bool {
+				return strings.HasPrefix(path, "/_assets/")
+			},
+			// Next.js
+			func(path string) bool {
+				return strings.HasPrefix(path, "/_next/")
+			},
+		))
+		configFuncs = append(configFuncs, poseidon.WithFingerprintedAssets(strings.Fields(config.FingerprintPatterns)...))
+	}
+
+	for _, item := range strings.Split(config.CacheRules, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		pattern, directives, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid cache rule: %s", item)
+		}
+
+		policy, err := poseidon.ParseCachePolicy(directives)
+		if err != nil {
+			return nil, err
+		}
+
+		configFuncs = append(configFuncs, poseidon.WithCacheRule(strings.TrimSpace(pattern), policy))
+	}
+
+	if config.NotFoundCache != "" {
+		policy, err := poseidon.ParseCachePolicy(config.NotFoundCache)
+		if err != nil {
+			return nil, err
+		}
+
+		configFuncs = append(configFuncs, poseidon.WithNotFoundCachePolicy(policy))
+	}
+
+	if config.FallbackCache != "" {
+		policy, err := poseidon.ParseCachePolicy(config.FallbackCache)
+		if err != nil {
+			return nil, err
+		}
+
+		configFuncs = append(configFuncs, poseidon.WithFallbackCachePolicy(policy))
+	}
+
+	mimeTypes, err := splitPairs(config.MIMETypes)
+	if err != nil {
+		return nil, err
+	}
+	configFuncs = append(configFuncs, poseidon.WithMIMETypes(mimeTypes))
+
+	errorPages, err := splitPairs(config.ErrorPages)
+	if err != nil {
+		return nil, err
+	}
+	for status, file := range errorPages {
+		statusCode, err := strconv.Atoi(status)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error page status: %s", status)
+		}
+
+		configFuncs = append(configFuncs, poseidon.WithErrorPage(statusCode, file))
+	}
+
+	if config.HiddenFiles {
+		configFuncs = append(configFuncs, poseidon.WithHiddenFiles())
+	}
+
+	configFuncs = append(configFuncs,
+		poseidon.WithAllowedHiddenPaths(splitList(config.AllowHidden)...),
+		poseidon.WithDeniedPaths(splitList(config.Deny)...),
+	)
+
+	if indexes := splitList(config.Index); len(indexes) > 0 {
+		configFuncs = append(configFuncs, poseidon.WithCustomIndex(indexes[0], indexes[1:]...))
+	}
+
+	if config.CleanURLs {
+		configFuncs = append(configFuncs, poseidon.WithCleanURLs())
+	}
+
+	if config.CleanURLRedirects {
+		configFuncs = append(configFuncs, poseidon.WithCleanURLRedirects())
+	}
+
+	if config.TrailingSlash != "" {
+		policy, ok := poseidon.ParseTrailingSlashPolicy(config.TrailingSlash)
+		if !ok {
+			return nil, fmt.Errorf("invalid trailing slash policy: %s", config.TrailingSlash)
+		}
+
+		configFuncs = append(configFuncs, poseidon.WithTrailingSlash(policy))
+	}
+
+	if config.Redirects {
+		configFuncs = append(configFuncs, poseidon.WithRedirectsFile())
+	}
+
+	for _, item := range strings.Split(config.RedirectRules, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		from, to, status, err := parseRedirectRule(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if status == http.StatusOK {
+			configFuncs = append(configFuncs, poseidon.WithRewrite(from, to))
+		} else {
+			configFuncs = append(configFuncs, poseidon.WithRedirect(from, to, status))
+		}
+	}
+
+	proxies, err := splitPairs(config.Proxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(proxies) > 0 {
+		timeout, err := time.ParseDuration(config.ProxyTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy timeout: %s", config.ProxyTimeout)
+		}
+
+		options := []poseidon.ProxyOption{poseidon.ProxyTimeout(timeout)}
+		if config.proxyCache != nil {
+			options = append(options, poseidon.ProxyCaching(config.proxyCache))
+		}
+
+		for prefix, upstream := range proxies {
+			configFuncs = append(configFuncs, poseidon.WithProxy(prefix, upstream, options...))
+		}
+	}
+
+	if config.Headers {
+		configFuncs = append(configFuncs, poseidon.WithHeadersFile())
+	}
+
+	// Browser navigations get the app first, then the not found file
+	htmlRequests := poseidon.MatchAccept("text/html")
+	if config.ClientSideRouting {
+		fallback := poseidon.FallbackIndex()
+		switch {
+		case config.appShell != "":
+			fallback = poseidon.FallbackFile(config.appShell, http.StatusOK)
+		case config.Nearest:
+			fallback = poseidon.FallbackNearestIndex()
+		}
+
+		configFuncs = append(configFuncs, poseidon.WithFallback(fallback, htmlRequests))
+	}
+
+	if config.NotFoundFile != "" {
+		fallback := poseidon.FallbackFile(config.NotFoundFile, http.StatusNotFound)
+		if config.Nearest {
+			fallback = poseidon.FallbackNearestFile(config.NotFoundFile, http.StatusNotFound)
+		}
+
+		configFuncs = append(configFuncs, poseidon.WithFallback(fallback, htmlRequests))
+	}
+
+	if config.Precompressed {
+		configFuncs = append(configFuncs, poseidon.WithPrecompressedFiles())
+	}
+
+	if config.Listing {
+		configFuncs = append(configFuncs, poseidon.WithDirectoryListing(splitList(config.ListingPaths)...))
+	}
+
+	if encodings := config.CompressionEncodings(); len(encodings) > 0 {
+		configFuncs = append(configFuncs, poseidon.WithCompression(encodings...))
+	}
+
+	return poseidon.New(fileSystem, configFuncs...)
+}
+
+// setupProxyCache creates the cache shared by the proxy routes of all sites.
+func (config *Config) setupProxyCache() error {
+	if config.ProxyCacheSize <= 0 {
+		return nil
+	}
+
+	maxBytes := int64(config.ProxyCacheSize) << 20
+	if config.ProxyCacheDir == "" {
+		config.proxyCache = poseidon.NewProxyCache(maxBytes)
+		return nil
+	}
+
+	cache, err := poseidon.NewDiskProxyCache(config.ProxyCacheDir, maxBytes)
+	if err != nil {
+		return err
+	}
+	config.proxyCache = cache
+
+	return nil
+}
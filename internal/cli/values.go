@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// listValue is a repeatable flag that collects its values into a separated
+// string, so it can share a Config field with an env var.
+type listValue struct {
+	target    *string
+	separator string
+	changed   bool
+}
+
+func newListValue(target *string, separator string) *listValue {
+	return &listValue{target: target, separator: separator}
+}
+
+func (value *listValue) Set(item string) error {
+	// The first value given on the command line replaces the default
+	if !value.changed || *value.target == "" {
+		*value.target = item
+	} else {
+		*value.target += value.separator + item
+	}
+	value.changed = true
+
+	return nil
+}
+
+func (value *listValue) String() string {
+	return *value.target
+}
+
+func (value *listValue) Type() string {
+	return "list"
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func splitPairs(value string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, item := range splitList(value) {
+		key, pairValue, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid key=value pair: %s", item)
+		}
+
+		pairs[strings.TrimSpace(key)] = strings.TrimSpace(pairValue)
+	}
+
+	return pairs, nil
+}
+
+// parseRedirectRule reads "from to [status]", where from may contain a space
+// after the method of ServeMux style patterns.
+func parseRedirectRule(value string) (string, string, int, error) {
+	fields := strings.Fields(value)
+
+	status := http.StatusMovedPermanently
+	if len(fields) > 2 {
+		if parsed, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+			status = parsed
+			fields = fields[:len(fields)-1]
+		}
+	}
+
+	if len(fields) < 2 {
+		return "", "", 0, fmt.Errorf("invalid redirect: %s", value)
+	}
+
+	return strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1], status, nil
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/lunagic/poseidon/poseidon"
+)
+
+// hostRouter serves the virtual hosts, with the main site answering the other
+// hosts. Without virtual hosts it is the main site itself.
+func (config *Config) hostRouter(service *poseidon.Service) (http.Handler, error) {
+	roots, err := splitPairs(config.VHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roots) == 0 && len(config.fileVHosts) == 0 {
+		return service, nil
+	}
+
+	hosts := map[string]*Config{}
+	for host, file := range config.fileVHosts {
+		if file.Root == nil && roots[host] == "" {
+			return nil, fmt.Errorf("vhost %s: missing root", host)
+		}
+
+		hostConfig, err := config.vhostConfig(host, file)
+		if err != nil {
+			return nil, err
+		}
+
+		hosts[host] = hostConfig
+	}
+
+	// Flags and env vars set the root of the hosts
+	for host, root := range roots {
+		hostConfig, found := hosts[host]
+		if !found {
+			copied := *config
+			hostConfig = &copied
+		}
+		hostConfig.Root = root
+
+		hosts[host] = hostConfig
+	}
+
+	router := poseidon.NewHostRouter()
+	router.HandleDefault(service)
+
+	for host, hostConfig := range hosts {
+		log.Printf("Serving %s from %s", host, hostConfig.Root)
+
+		if !strings.HasPrefix(host, "*.") {
+			hostService, err := hostConfig.newService(poseidon.DirFS(hostConfig.Root))
+			if err != nil {
+				return nil, fmt.Errorf("vhost %s: %w", host, err)
+			}
+
+			router.Handle(host, hostService)
+			continue
+		}
+
+		err := router.HandleWildcard(host, func(label string) (http.Handler, error) {
+			labelConfig := *hostConfig
+			labelConfig.Root = strings.ReplaceAll(hostConfig.Root, "*", label)
+
+			stat, err := os.Stat(labelConfig.Root)
+			if err != nil {
+				return nil, err
+			}
+			if !stat.IsDir() {
+				return nil, fs.ErrNotExist
+			}
+
+			labelService, err := labelConfig.newService(poseidon.DirFS(labelConfig.Root))
+			if err != nil {
+				log.Printf("vhost %s: %v", strings.Replace(host, "*", label, 1), err)
+			}
+
+			return labelService, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return router, nil
+}
+
+// vhostConfig applies the settings of a virtual host from the config file on
+// top of the settings of the main site.
+func (config *Config) vhostConfig(host string, file fileConfig) (*Config, error) {
+	switch {
+	case file.Host != nil, file.Port != nil:
+		return nil, fmt.Errorf("vhost %s: host and port can only be set at the top level", host)
+	case file.Preset != nil:
+		return nil, fmt.Errorf("vhost %s: preset can only be set at the top level", host)
+	case file.VHosts != nil:
+		return nil, errors.New("vhosts can't be nested")
+	}
+
+	hostConfig := *config
+	if _, err := file.apply(&hostConfig); err != nil {
+		return nil, fmt.Errorf("vhost %s: %w", host, err)
+	}
+
+	return &hostConfig, nil
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lunagic/poseidon/poseidon"
+)
+
+func writeSite(t *testing.T, root string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostRouterWithoutVHosts(t *testing.T) {
+	config := defaultConfig()
+
+	service, err := config.newService(poseidon.DirFS(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := config.hostRouter(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handler != service {
+		t.Fatal("Expected the main site without virtual hosts")
+	}
+}
+
+func TestHostRouterVHosts(t *testing.T) {
+	directory := t.TempDir()
+	writeSite(t, filepath.Join(directory, "main"), "main")
+	writeSite(t, filepath.Join(directory, "docs"), "docs")
+	writeSite(t, filepath.Join(directory, "previews", "pr-1"), "pr-1")
+
+	config := defaultConfig()
+	config.VHosts = "docs.example.com=" + filepath.Join(directory, "docs") + ", *.preview.example.com=" + filepath.Join(directory, "previews", "*")
+
+	service, err := config.newService(poseidon.DirFS(filepath.Join(directory, "main")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := config.hostRouter(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for host, expectedBody := range map[string]string{
+		"docs.example.com":         "docs",
+		"pr-1.preview.example.com": "pr-1",
+		"pr-2.preview.example.com": "main",
+		"example.com":              "main",
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Host = host
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Body.String() != expectedBody {
+			t.Fatalf("Unexpected body for %s, Got: %q, Expected: %q", host, recorder.Body.String(), expectedBody)
+		}
+	}
+}
+
+func TestHostRouterInvalidVHost(t *testing.T) {
+	config := defaultConfig()
+	config.VHosts = "docs.example.com"
+
+	if _, err := config.hostRouter(nil); err == nil {
+		t.Fatal("Expected an error for a virtual host without a root")
+	}
+}
+
+func TestVHostConfigTopLevelSettings(t *testing.T) {
+	port := 8080
+	preset := "vite"
+
+	for _, file := range []fileConfig{
+		{Port: &port},
+		{Preset: &preset},
+		{VHosts: map[string]fileConfig{}},
+	} {
+		if _, err := defaultConfig().vhostConfig("docs.example.com", file); err == nil {
+			t.Fatalf("Expected an error for %+v", file)
+		}
+	}
+}
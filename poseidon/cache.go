@@ -0,0 +1,244 @@
+package poseidon
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy describes the caching headers of a response.
+type CachePolicy struct {
+	MaxAge               time.Duration
+	SharedMaxAge         time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	Private              bool
+	NoStore              bool
+	NoCache              bool
+	MustRevalidate       bool
+	Immutable            bool
+	// CDNCacheControl and SurrogateControl are sent as is when not empty
+	CDNCacheControl  string
+	SurrogateControl string
+}
+
+// CacheControl formats the policy as a Cache-Control header value.
+func (policy CachePolicy) CacheControl() string {
+	if policy.NoStore {
+		return "no-store"
+	}
+
+	directives := []string{"public"}
+	if policy.Private {
+		directives[0] = "private"
+	}
+	if policy.NoCache {
+		directives = append(directives, "no-cache")
+	}
+	directives = append(directives, "max-age="+seconds(policy.MaxAge))
+	if policy.SharedMaxAge > 0 {
+		directives = append(directives, "s-maxage="+seconds(policy.SharedMaxAge))
+	}
+	if policy.StaleWhileRevalidate > 0 {
+		directives = append(directives, "stale-while-revalidate="+seconds(policy.StaleWhileRevalidate))
+	}
+	if policy.StaleIfError > 0 {
+		directives = append(directives, "stale-if-error="+seconds(policy.StaleIfError))
+	}
+	if policy.MustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+	if policy.Immutable {
+		directives = append(directives, "immutable")
+	}
+
+	return strings.Join(directives, ", ")
+}
+
+func seconds(duration time.Duration) string {
+	return strconv.FormatInt(int64(duration/time.Second), 10)
+}
+
+func (policy CachePolicy) apply(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", policy.CacheControl())
+	w.Header().Del("Pragma")
+	w.Header().Del("Expires")
+
+	setOrDelete(w.Header(), "CDN-Cache-Control", policy.CDNCacheControl)
+	setOrDelete(w.Header(), "Surrogate-Control", policy.SurrogateControl)
+}
+
+func setOrDelete(header http.Header, key string, value string) {
+	if value == "" {
+		header.Del(key)
+		return
+	}
+
+	header.Set(key, value)
+}
+
+// ParseCachePolicy reads a policy from Cache-Control directives, for example
+// "public, max-age=60, stale-while-revalidate=600". Directives prefixed with
+// "cdn-" or "surrogate-" end up in CDN-Cache-Control or Surrogate-Control.
+func ParseCachePolicy(value string) (CachePolicy, error) {
+	policy := CachePolicy{}
+	cdn := []string{}
+	surrogate := []string{}
+
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "" {
+			continue
+		}
+
+		if rest, found := strings.CutPrefix(directive, "cdn-"); found {
+			cdn = append(cdn, rest)
+			continue
+		}
+		if rest, found := strings.CutPrefix(directive, "surrogate-"); found {
+			surrogate = append(surrogate, rest)
+			continue
+		}
+
+		name, argument, hasArgument := strings.Cut(directive, "=")
+		var duration time.Duration
+		if hasArgument {
+			count, err := strconv.ParseInt(argument, 10, 64)
+			if err != nil || count < 0 {
+				return CachePolicy{}, fmt.Errorf("invalid cache directive: %s", directive)
+			}
+			duration = time.Duration(count) * time.Second
+		}
+
+		switch name {
+		case "public":
+			policy.Private = false
+		case "private":
+			policy.Private = true
+		case "no-store":
+			policy.NoStore = true
+		case "no-cache":
+			policy.NoCache = true
+		case "must-revalidate":
+			policy.MustRevalidate = true
+		case "immutable":
+			policy.Immutable = true
+		case "max-age":
+			policy.MaxAge = duration
+		case "s-maxage":
+			policy.SharedMaxAge = duration
+		case "stale-while-revalidate":
+			policy.StaleWhileRevalidate = duration
+		case "stale-if-error":
+			policy.StaleIfError = duration
+		default:
+			return CachePolicy{}, fmt.Errorf("unknown cache directive: %s", directive)
+		}
+	}
+
+	policy.CDNCacheControl = strings.Join(cdn, ", ")
+	policy.SurrogateControl = strings.Join(surrogate, ", ")
+
+	return policy, nil
+}
+
+type cacheRule struct {
+	pattern string
+	policy  CachePolicy
+}
+
+// matches checks the pattern against the file path or the content type.
+func (rule cacheRule) matches(name string, contentType string) bool {
+	pattern := rule.pattern
+
+	// Extensions such as ".css"
+	if strings.HasPrefix(pattern, ".") && !strings.Contains(pattern, "/") {
+		return strings.HasSuffix(strings.ToLower(name), strings.ToLower(pattern))
+	}
+
+	// Content types such as "text/html" or "image/*"
+	if !strings.HasPrefix(pattern, "/") && strings.Contains(pattern, "/") {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		mainType, _, _ := strings.Cut(mediaType, "/")
+
+		return pattern == mediaType || pattern == mainType+"/*"
+	}
+
+	name = strings.Trim(name, "/")
+
+	return matchPathPattern(pattern, name, strings.Split(name, "/"))
+}
+
+// WithCacheRule sets the caching headers of files matching the pattern.
+// Patterns are extensions (".css"), content types ("image/*"), globs matching
+// any path segment ("*.html") or globs on the path starting with a slash
+// ("/docs/*", "/_assets/"). The first matching rule applies and rules take
+// precedence over WithCachePolicy and WithFingerprintedAssets.
+func WithCacheRule(pattern string, policy CachePolicy) ConfigFunc {
+	return func(service *Service) error {
+		if pattern == "" {
+			return fmt.Errorf("empty cache rule pattern")
+		}
+
+		service.cacheRules = append(service.cacheRules, cacheRule{
+			pattern: pattern,
+			policy:  policy,
+		})
+
+		return nil
+	}
+}
+
+// WithNotFoundCachePolicy sets the caching headers of 404 responses, which
+// aren't cached by default.
+func WithNotFoundCachePolicy(policy CachePolicy) ConfigFunc {
+	return func(service *Service) error {
+		service.notFoundCachePolicy = &policy
+
+		return nil
+	}
+}
+
+// WithFallbackCachePolicy sets the caching headers of files served in place
+// of a missing one with the 200 status, such as the index for client side
+// routing.
+func WithFallbackCachePolicy(policy CachePolicy) ConfigFunc {
+	return func(service *Service) error {
+		service.fallbackCachePolicy = &policy
+
+		return nil
+	}
+}
+
+// cacheFallback sets the caching headers of responses that aren't the
+// requested file.
+func (service *Service) cacheFallback(w http.ResponseWriter, status int) {
+	switch {
+	case status == http.StatusNotFound && service.notFoundCachePolicy != nil:
+		service.notFoundCachePolicy.apply(w)
+	case status == http.StatusOK && service.fallbackCachePolicy != nil:
+		service.fallbackCachePolicy.apply(w)
+	default:
+		doNotCache(w)
+	}
+}
+
+// cacheFile sets the caching headers of a file served with the 200 status.
+func (service *Service) cacheFile(w http.ResponseWriter, r *http.Request, name string, contentType string) {
+	if isRewritten(r) {
+		if service.fallbackCachePolicy != nil {
+			service.fallbackCachePolicy.apply(w)
+		}
+		return
+	}
+
+	for _, rule := range service.cacheRules {
+		if rule.matches(name, contentType) {
+			rule.policy.apply(w)
+			return
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package poseidon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const defaultCompressionMinSize = 1024
+
+var defaultCompressionEncodings = []string{"br", "zstd", "gzip"}
+
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+var encoderPools = map[string]*sync.Pool{
+	"br": {
+		New: func() any {
+			return brotli.NewWriterLevel(nil, 5)
+		},
+	},
+	"zstd": {
+		New: func() any {
+			// Browsers only support windows of up to 8MB (RFC 9659)
+			encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<23))
+			if err != nil {
+				panic(err)
+			}
+
+			return encoder
+		},
+	},
+	"gzip": {
+		New: func() any {
+			return gzip.NewWriter(nil)
+		},
+	},
+}
+
+var compressibleTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/wasm",
+	"application/x-javascript",
+	"application/xml",
+	"application/vnd.ms-fontobject",
+	"font/otf",
+	"font/ttf",
+	"image/bmp",
+	"image/svg+xml",
+	"image/x-icon",
+	"image/vnd.microsoft.icon",
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+
+	for _, compressibleType := range compressibleTypes {
+		if mediaType == compressibleType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func WithCompression(encodings ...string) ConfigFunc {
+	return func(service *Service) error {
+		if len(encodings) == 0 {
+			encodings = defaultCompressionEncodings
+		}
+
+		for _, encoding := range encodings {
+			if _, ok := encoderPools[encoding]; !ok {
+				return fmt.Errorf("unsupported compression encoding: %s", encoding)
+			}
+		}
+
+		service.compression = true
+
+		return WithMiddleware(compressionMiddleware(encodings, func() int {
+			return service.compressionMinSize
+		}))(service)
+	}
+}
+
+func WithCompressionMinSize(size int) ConfigFunc {
+	return func(service *Service) error {
+		service.compressionMinSize = size
+
+		return nil
+	}
+}
+
+// Deprecated: use WithCompression instead.
+func WithGZipCompression() ConfigFunc {
+	return func(service *Service) error {
+		service.compression = true
+
+		return WithMiddleware(compressionMiddleware([]string{"gzip"}, func() int {
+			return 0
+		}))(service)
+	}
+}
+
+func compressionMiddleware(encodings []string, minSize func() int) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Nothing to negotiate for clients without any preference
+			if r.Header.Get("Accept-Encoding") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			compressionWriter := &compressionResponseWriter{
+				ResponseWriter: w,
+				request:        r,
+				encodings:      encodings,
+				minSize:        minSize(),
+			}
+			completed := false
+			defer func() {
+				// A handler that panicked must not end up as a complete response
+				if !completed {
+					compressionWriter.discard()
+					return
+				}
+
+				_ = compressionWriter.Close()
+			}()
+
+			next.ServeHTTP(compressionWriter, r)
+			completed = true
+		})
+	}
+}
+
+// compressionResponseWriter decides whether to compress once the headers and
+// enough of the body are known.
+type compressionResponseWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	encodings   []string
+	minSize     int
+	statusCode  int
+	wroteHeader bool
+	decided     bool
+	encoding    string
+	encoder     encoder
+	buffer      bytes.Buffer
+}
+
+func (w *compressionResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+
+	// Informational responses are passed through untouched
+	if statusCode >= 100 && statusCode < 200 {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.wroteHeader = true
+	w.statusCode = statusCode
+
+	if w.varies() {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+
+	encoding := w.negotiate()
+	if encoding == "" {
+		w.decided = true
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.encoding = encoding
+
+	// Bodies of unknown length are buffered until the minimum size is reached
+	if w.Header().Get("Content-Length") == "" && w.minSize > 0 {
+		return
+	}
+
+	w.startCompression()
+}
+
+// varies tells whether the representation could have been compressed, which
+// 304 responses have to announce as well.
+func (w *compressionResponseWriter) varies() bool {
+	if !w.compressibleStatus() && w.statusCode != http.StatusNotModified {
+		return false
+	}
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+
+	return isCompressible(header.Get("Content-Type"))
+}
+
+func (w *compressionResponseWriter) negotiate() string {
+	if !w.compressibleStatus() || !w.varies() {
+		return ""
+	}
+
+	if contentLength := w.Header().Get("Content-Length"); contentLength != "" {
+		length, err := strconv.Atoi(contentLength)
+		if err != nil || length < w.minSize {
+			return ""
+		}
+	}
+
+	return negotiateEncoding(w.request.Header.Get("Accept-Encoding"), w.encodings)
+}
+
+func (w *compressionResponseWriter) compressibleStatus() bool {
+	switch {
+	case w.statusCode == http.StatusNoContent, w.statusCode == http.StatusPartialContent:
+		return false
+	case w.statusCode >= 200 && w.statusCode < 300:
+		return true
+	case w.statusCode >= 400:
+		return true
+	default:
+		// Redirects and 304 responses have no meaningful body
+		return false
+	}
+}
+
+func (w *compressionResponseWriter) startCompression() {
+	w.decided = true
+
+	header := w.Header()
+	header.Set("Content-Encoding", w.encoding)
+	// The length of the compressed body is not known ahead of time and byte
+	// ranges would refer to the uncompressed representation
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	// The compressed representation is no longer byte for byte identical
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	w.ResponseWriter.WriteHeader(w.statusCode)
+
+	w.encoder = encoderPools[w.encoding].Get().(encoder)
+	// HEAD responses announce the compression without a body
+	if w.request.Method == http.MethodHead {
+		w.encoder.Reset(io.Discard)
+	} else {
+		w.encoder.Reset(w.ResponseWriter)
+	}
+}
+
+func (w *compressionResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+
+	if w.decided {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buffer.Write(b)
+	if w.buffer.Len() < w.minSize {
+		return len(b), nil
+	}
+
+	w.startCompression()
+	if _, err := w.encoder.Write(w.buffer.Bytes()); err != nil {
+		return 0, err
+	}
+	w.buffer.Reset()
+
+	return len(b), nil
+}
+
+func (w *compressionResponseWriter) Flush() {
+	if !w.decided && w.wroteHeader {
+		// Flushing means the rest of the body can't be waited for
+		w.startCompression()
+		_, _ = w.encoder.Write(w.buffer.Bytes())
+		w.buffer.Reset()
+	}
+
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressionResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressionResponseWriter) Close() error {
+	// The body never reached the minimum size, so send it as is
+	if w.wroteHeader && !w.decided {
+		w.decided = true
+		w.Header().Set("Content-Length", strconv.Itoa(w.buffer.Len()))
+		w.ResponseWriter.WriteHeader(w.statusCode)
+		_, err := w.ResponseWriter.Write(w.buffer.Bytes())
+
+		return err
+	}
+
+	if w.encoder == nil {
+		return nil
+	}
+
+	err := w.encoder.Close()
+	encoderPools[w.encoding].Put(w.encoder)
+	w.encoder = nil
+
+	return err
+}
+
+// discard drops the buffered body without sending it.
+func (w *compressionResponseWriter) discard() {
+	w.buffer.Reset()
+
+	if w.encoder != nil {
+		w.encoder.Reset(io.Discard)
+		encoderPools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+// addVary adds the header name to Vary unless it is already listed.
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", name)
+}
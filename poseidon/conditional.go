@@ -0,0 +1,207 @@
+package poseidon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+type conditionResult int
+
+const (
+	conditionNone conditionResult = iota
+	conditionTrue
+	conditionFalse
+)
+
+// fileETag builds a strong validator from the modification time and size, or
+// from the content without a modification time (embed.FS).
+func fileETag(file fs.File, stat fs.FileInfo) string {
+	if !stat.ModTime().IsZero() {
+		return fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size())
+	}
+
+	seeker, ok := file.(io.Seeker)
+	if !ok {
+		return ""
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+
+	return "\"" + hex.EncodeToString(hash.Sum(nil)[:16]) + "\""
+}
+
+// setValidators writes the ETag and Last-Modified headers for the file.
+func setValidators(w http.ResponseWriter, etag string, modTime time.Time) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	if !modTime.IsZero() && !modTime.Equal(time.Unix(0, 0)) {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// checkPreconditions follows RFC 9110 section 13.2.2 and returns true when it
+// wrote a 304 or 412.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	result := checkIfMatch(r, etag)
+	if result == conditionNone {
+		result = checkIfUnmodifiedSince(r, modTime)
+	}
+	if result == conditionFalse {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return true
+	}
+
+	switch checkIfNoneMatch(r, etag) {
+	case conditionFalse:
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			writeNotModified(w)
+			return true
+		}
+
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return true
+	case conditionNone:
+		if checkIfModifiedSince(r, modTime) == conditionFalse {
+			writeNotModified(w)
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	// RFC 9110 section 15.4.5: a 304 must not describe a representation body
+	header := w.Header()
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	if header.Get("ETag") != "" {
+		header.Del("Last-Modified")
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+func checkIfMatch(r *http.Request, etag string) conditionResult {
+	value := r.Header.Get("If-Match")
+	if value == "" {
+		return conditionNone
+	}
+
+	for _, candidate := range splitETags(value) {
+		if candidate == "*" && etag != "" {
+			return conditionTrue
+		}
+
+		if strongETagMatch(candidate, etag) {
+			return conditionTrue
+		}
+	}
+
+	return conditionFalse
+}
+
+func checkIfUnmodifiedSince(r *http.Request, modTime time.Time) conditionResult {
+	value := r.Header.Get("If-Unmodified-Since")
+	if value == "" || modTime.IsZero() {
+		return conditionNone
+	}
+
+	since, err := http.ParseTime(value)
+	if err != nil {
+		return conditionNone
+	}
+
+	// Header dates have a one second resolution
+	if modTime.Truncate(time.Second).After(since) {
+		return conditionFalse
+	}
+
+	return conditionTrue
+}
+
+func checkIfNoneMatch(r *http.Request, etag string) conditionResult {
+	value := r.Header.Get("If-None-Match")
+	if value == "" {
+		return conditionNone
+	}
+
+	for _, candidate := range splitETags(value) {
+		if candidate == "*" && etag != "" {
+			return conditionFalse
+		}
+
+		if weakETagMatch(candidate, etag) {
+			return conditionFalse
+		}
+	}
+
+	return conditionTrue
+}
+
+func checkIfModifiedSince(r *http.Request, modTime time.Time) conditionResult {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return conditionNone
+	}
+
+	value := r.Header.Get("If-Modified-Since")
+	if value == "" || modTime.IsZero() {
+		return conditionNone
+	}
+
+	since, err := http.ParseTime(value)
+	if err != nil {
+		return conditionNone
+	}
+
+	// Header dates have a one second resolution
+	if !modTime.Truncate(time.Second).After(since) {
+		return conditionFalse
+	}
+
+	return conditionTrue
+}
+
+func splitETags(value string) []string {
+	etags := []string{}
+	for _, part := range strings.Split(value, ",") {
+		part = textproto.TrimString(part)
+		if part != "" {
+			etags = append(etags, part)
+		}
+	}
+
+	return etags
+}
+
+func strongETagMatch(a string, b string) bool {
+	if a == "" || b == "" || strings.HasPrefix(a, "W/") || strings.HasPrefix(b, "W/") {
+		return false
+	}
+
+	return a == b
+}
+
+func weakETagMatch(a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
@@ -1,48 +1,15 @@
 package poseidon
 
 import (
-	"compress/gzip"
 	"html/template"
 	"io"
 	"io/fs"
-	"mime"
 	"net/http"
-	"path/filepath"
-	"strings"
+	"strconv"
 )
 
 type ConfigFunc func(service *Service) error
 
-type gzipResponseWriter struct {
-	io.Writer
-	http.ResponseWriter
-}
-
-func (w gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
-}
-
-func WithGZipCompression() ConfigFunc {
-	return WithMiddleware(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Skip if browser does not support gzip
-			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			w.Header().Set("Content-Encoding", "gzip")
-			gzipWriter := gzip.NewWriter(w)
-			defer func() {
-				_ = gzipWriter.Close()
-			}()
-			gzipResponseWriter := gzipResponseWriter{Writer: gzipWriter, ResponseWriter: w}
-
-			next.ServeHTTP(gzipResponseWriter, r)
-		})
-	})
-}
-
 func WithMiddleware(middleware Middleware) ConfigFunc {
 	return func(service *Service) error {
 		service.middlewares = append(service.middlewares, middleware)
@@ -69,19 +36,25 @@ func WithCachePolicy(checkers ...func(string) bool) ConfigFunc {
 	})
 }
 
-func WithCustomIndex(index string) ConfigFunc {
+// WithCustomIndex sets the index files of directories. When several are
+// given the first one that exists is served.
+func WithCustomIndex(index string, fallbacks ...string) ConfigFunc {
 	return func(service *Service) error {
 		service.index = index
+		service.indexes = append([]string{index}, fallbacks...)
 
 		return nil
 	}
 }
 
+// WithCustomNotFoundHandler sets the handler for HTML requests that neither
+// a file nor a fallback answered. Only the last not found option counts, use
+// WithFallback to combine several.
 func WithCustomNotFoundHandler(handler http.Handler) ConfigFunc {
 	return func(service *Service) error {
 		service.notFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !strings.Contains(r.Header.Get("accept"), "text/html") {
-				http.NotFound(w, r)
+			if !acceptsHTML(r) {
+				service.ServeError(w, r, http.StatusNotFound)
 				return
 			}
 
@@ -93,38 +66,14 @@ func WithCustomNotFoundHandler(handler http.Handler) ConfigFunc {
 }
 
 func WithCustomNotFoundFile(filePath string) ConfigFunc {
-	return ConfigFunc(func(service *Service) error {
-		return WithCustomNotFoundHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Open the custom not found file
-			file, err := service.fileSystem.Open(filePath)
-			if err != nil {
-				doNotCache(w)
-				http.NotFound(w, r)
-				return
-			}
-			defer func() {
-				_ = file.Close()
-			}()
-
-			doNotCache(w)
-			writeFile(w, file, http.StatusNotFound)
-		}))(service)
-	})
+	return func(service *Service) error {
+		return WithCustomNotFoundHandler(service.fallbackHandler(FallbackFile(filePath, http.StatusNotFound)))(service)
+	}
 }
 
 func WithClientSideRouting() ConfigFunc {
 	return func(service *Service) error {
-		return WithCustomNotFoundHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Prevent infinite recursion
-			if r.URL.Path == service.index {
-				http.NotFound(w, r)
-				return
-			}
-
-			// Change the path to the index and retry
-			r.URL.Path = service.index
-			service.ServeHTTP(w, r)
-		}))(service)
+		return WithCustomNotFoundHandler(service.fallbackHandler(FallbackIndex()))(service)
 	}
 }
 
@@ -141,7 +90,14 @@ func WithClientSideRoutingAndServerSideRendering(provider SSRProvider, middlewar
 			middlewares.Apply(
 				http.HandlerFunc(
 					func(w http.ResponseWriter, r *http.Request) {
-						if err := indexTemplate.Execute(w, provider.HandleRequest(r)); err != nil {
+						service.cacheFallback(w, http.StatusOK)
+						data := ssrTemplateData{
+							SSRPayload: provider.HandleRequest(r),
+							BasePath:   MountPrefix(r),
+							Nonce:      requestNonce(r),
+						}
+						data.HeaderContent = addNonce(data.HeaderContent, data.Nonce)
+						if err := indexTemplate.Execute(w, data); err != nil {
 							_, _ = w.Write([]byte(err.Error()))
 						}
 					},
@@ -163,10 +119,55 @@ func cacheForever(w http.ResponseWriter) {
 	w.Header().Del("Expires")
 }
 
-func writeFile(w http.ResponseWriter, file fs.File, status int) {
+func (service *Service) writeFile(w http.ResponseWriter, r *http.Request, file fs.File, name string, status int) {
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if status == http.StatusOK && service.refuseFileMethod(w, r) {
+		return
+	}
+
 	stat, _ := file.Stat()
-	w.Header().Set("content-type", mime.TypeByExtension(filepath.Ext(stat.Name())))
+	// Encoded content can't be sniffed
+	var sniffable fs.File = file
+	if w.Header().Get("Content-Encoding") != "" {
+		sniffable = nil
+	}
+	contentType := service.contentType(name, sniffable)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Only successful responses can be revalidated or split into ranges
+	if status == http.StatusOK {
+		service.cacheFile(w, r, name, contentType)
+
+		// 304 responses carry no Content-Type for the compression to go by
+		if service.compression && isCompressible(contentType) {
+			addVary(w.Header(), "Accept-Encoding")
+		}
+
+		etag := fileETag(file, stat)
+		setValidators(w, etag, stat.ModTime())
+		if checkPreconditions(w, r, etag, stat.ModTime()) {
+			return
+		}
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		if writeRanges(w, r, file, stat, etag, contentType) {
+			return
+		}
+	}
+
+	w.Header().Set("content-type", contentType)
+	if stat.Mode().IsRegular() {
+		w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	}
 	w.WriteHeader(status)
+
+	// HEAD responses carry the headers of the GET response without the body
+	if r.Method == http.MethodHead {
+		return
+	}
+
 	_, _ = io.Copy(w, file)
-	_ = file.Close()
 }
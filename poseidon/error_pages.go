@@ -0,0 +1,97 @@
+package poseidon
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+type errorPayload struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// WithErrorPage serves the file for HTML requests answered with the status,
+// for example WithErrorPage(http.StatusServiceUnavailable, "503.html").
+func WithErrorPage(status int, filePath string) ConfigFunc {
+	return func(service *Service) error {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("invalid error page status: %d", status)
+		}
+
+		service.errorPages[status] = filePath
+
+		return nil
+	}
+}
+
+// ServeError answers the request with the status. Browsers get the error
+// page configured for the status, API clients get JSON and everything else
+// gets plain text.
+func (service *Service) ServeError(w http.ResponseWriter, r *http.Request, status int) {
+	service.cacheFallback(w, status)
+
+	if prefersJSON(r) {
+		RespondJSON(w, status, errorPayload{
+			Status: status,
+			Error:  http.StatusText(status),
+		})
+		return
+	}
+
+	if filePath, ok := service.errorPages[status]; ok && acceptsHTML(r) {
+		if file, err := service.fileSystem.Open(filePath); err == nil {
+			service.writeFile(w, r, file, filePath, status)
+			return
+		}
+	}
+
+	http.Error(w, errorText(status), status)
+}
+
+func errorText(status int) string {
+	// Match the body of http.NotFound
+	if status == http.StatusNotFound {
+		return "404 page not found"
+	}
+
+	return fmt.Sprintf("%d %s", status, strings.ToLower(http.StatusText(status)))
+}
+
+// recoverer turns panics into logged 500 responses instead of dropping the
+// connection.
+func (service *Service) recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responseWriter := NewResponseWriter(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+
+			// Aborting is how handlers deliberately cut a response short
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+
+			if !responseWriter.Written() {
+				// Drop whatever the failed handler prepared, which includes the
+				// security headers when it panicked inside their middleware
+				for key := range responseWriter.Header() {
+					responseWriter.Header().Del(key)
+				}
+				if service.securityHeaders != nil {
+					service.securityHeaders.apply(responseWriter.Header(), "")
+				}
+
+				service.ServeError(responseWriter, r, http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(responseWriter, r)
+	})
+}
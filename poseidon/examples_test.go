@@ -33,3 +33,29 @@ func ExampleNew() {
 		log.Fatal(err)
 	}
 }
+
+func ExampleMount() {
+	newService := func(root string, configFuncs ...poseidon.ConfigFunc) *poseidon.Service {
+		service, err := poseidon.New(poseidon.DirFS(root), configFuncs...)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return service
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", newService("marketing"))
+	mux.Handle("/app/", poseidon.Mount("/app", newService("app", poseidon.WithClientSideRouting())))
+	mux.Handle("/docs/", poseidon.Mount("/docs", newService("docs")))
+
+	server := &http.Server{
+		Addr:    "127.0.0.1:3000",
+		Handler: mux,
+	}
+
+	log.Printf("Listing on http://%s", server.Addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,221 @@
+package poseidon
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// RequestMatcher reports whether a request qualifies for something, such as
+// a fallback.
+type RequestMatcher func(r *http.Request) bool
+
+// MatchPathPrefix matches requests whose path starts with any of the prefixes.
+func MatchPathPrefix(prefixes ...string) RequestMatcher {
+	return func(r *http.Request) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// MatchExtension matches requests whose path has any of the extensions. An
+// empty extension matches paths without one.
+func MatchExtension(extensions ...string) RequestMatcher {
+	return func(r *http.Request) bool {
+		return slices.Contains(extensions, strings.ToLower(path.Ext(r.URL.Path)))
+	}
+}
+
+// MatchAccept matches requests that explicitly accept any of the media
+// types, for example MatchAccept("text/html") for browser navigations. A
+// bare */* doesn't count.
+func MatchAccept(mediaTypes ...string) RequestMatcher {
+	return func(r *http.Request) bool {
+		accept := slices.DeleteFunc(parseQualityList(r.Header.Get("Accept")), func(value qualityValue) bool {
+			return value.Value == "*/*"
+		})
+
+		for _, mediaType := range mediaTypes {
+			if mediaTypeQuality(accept, mediaType) > 0 {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Fallback answers a request that no file was found for. It returns false
+// to hand the request to the next fallback in the chain.
+type Fallback func(service *Service, w http.ResponseWriter, r *http.Request) bool
+
+type fallbackRule struct {
+	fallback Fallback
+	matchers []RequestMatcher
+}
+
+func (rule fallbackRule) matches(r *http.Request) bool {
+	for _, matcher := range rule.matchers {
+		if !matcher(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WithFallback appends a fallback to the chain tried when no file matches
+// the request. Fallbacks are tried in the order they were added and only
+// for requests matching all of the matchers. When none of them answers the
+// request, the not found handler does.
+func WithFallback(fallback Fallback, matchers ...RequestMatcher) ConfigFunc {
+	return func(service *Service) error {
+		service.fallbacks = append(service.fallbacks, fallbackRule{
+			fallback: fallback,
+			matchers: matchers,
+		})
+
+		return nil
+	}
+}
+
+// FallbackFile serves the file with the status, for example a 404 page or
+// the shell of a single page application with 200.
+func FallbackFile(filePath string, status int) Fallback {
+	return func(service *Service, w http.ResponseWriter, r *http.Request) bool {
+		file, err := service.fileSystem.Open(filePath)
+		if err != nil {
+			return false
+		}
+
+		service.cacheFallback(w, status)
+		service.writeFile(w, r, file, filePath, status)
+
+		return true
+	}
+}
+
+// FallbackIndex serves the index as if it had been requested, which is what
+// client side routing needs.
+func FallbackIndex() Fallback {
+	return func(service *Service, w http.ResponseWriter, r *http.Request) bool {
+		// Prevent infinite recursion
+		if isRewritten(r) {
+			return false
+		}
+
+		if _, err := fs.Stat(service.fileSystem, service.index); err != nil {
+			return false
+		}
+
+		// Change the path to the index and retry
+		service.ServeHTTP(w, rewriteRequest(r, "/"+service.index))
+
+		return true
+	}
+}
+
+// FallbackNearestFile serves the file with the given name from the closest
+// directory above the requested path, for example the 404.html of a docs
+// section, with the status. The root is the last directory it looks in.
+func FallbackNearestFile(name string, status int) Fallback {
+	return func(service *Service, w http.ResponseWriter, r *http.Request) bool {
+		filePath, found := service.nearestFile(r.URL.Path, name)
+		if !found {
+			return false
+		}
+
+		return FallbackFile(filePath, status)(service, w, r)
+	}
+}
+
+// FallbackNearestIndex serves the index of the closest directory above the
+// requested path, so several client side routed apps can live in
+// subdirectories of one root.
+func FallbackNearestIndex() Fallback {
+	return func(service *Service, w http.ResponseWriter, r *http.Request) bool {
+		if isRewritten(r) {
+			return false
+		}
+
+		filePath, found := service.nearestFile(r.URL.Path, service.index)
+		if !found {
+			return false
+		}
+
+		service.ServeHTTP(w, rewriteRequest(r, "/"+filePath))
+
+		return true
+	}
+}
+
+// nearestFile looks for the file in the directory of the URL path and then
+// in each of its parents.
+func (service *Service) nearestFile(urlPath string, name string) (string, bool) {
+	dir := path.Dir(path.Clean("/" + urlPath))
+	if strings.HasSuffix(urlPath, "/") {
+		dir = path.Clean("/" + urlPath)
+	}
+
+	for {
+		filePath := strings.TrimPrefix(path.Join(dir, name), "/")
+		if !service.isDenied(filePath) {
+			if stat, err := fs.Stat(service.fileSystem, filePath); err == nil && stat.Mode().IsRegular() {
+				return filePath, true
+			}
+		}
+
+		if dir == "/" {
+			return "", false
+		}
+
+		dir = path.Dir(dir)
+	}
+}
+
+// FallbackHandler hands the request to the handler.
+func FallbackHandler(handler http.Handler) Fallback {
+	return func(service *Service, w http.ResponseWriter, r *http.Request) bool {
+		handler.ServeHTTP(w, r)
+
+		return true
+	}
+}
+
+// FallbackStatus answers with the status through ServeError.
+func FallbackStatus(status int) Fallback {
+	return func(service *Service, w http.ResponseWriter, r *http.Request) bool {
+		service.ServeError(w, r, status)
+
+		return true
+	}
+}
+
+// serveNotFound runs the fallback chain and ends with the not found handler.
+func (service *Service) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	service.cacheFallback(w, http.StatusNotFound)
+
+	for _, rule := range service.fallbacks {
+		if rule.matches(r) && rule.fallback(service, w, r) {
+			return
+		}
+	}
+
+	service.notFoundHandler.ServeHTTP(w, r)
+}
+
+// fallbackHandler adapts a fallback to the not found handler.
+func (service *Service) fallbackHandler(fallback Fallback) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !fallback(service, w, r) {
+			service.ServeError(w, r, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package poseidon
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultFingerprintPatterns match the hashed file names and immutable
+// directories written by Vite, Astro, SvelteKit, Nuxt, Next.js and webpack.
+// A subexpression named "hash" has to look like a content hash for the
+// pattern to match.
+var DefaultFingerprintPatterns = []string{
+	// webpack and friends: main.3e1f2a7c.css
+	`[.-](?P<hash>[0-9a-f]{8,64})\.[0-9a-z]+$`,
+	// Rollup based builds: index-BxWq3F2a.js
+	`[.-](?P<hash>[0-9A-Za-z_-]{8})\.[0-9a-z]+$`,
+	`^/_app/immutable/`,
+	`^/_astro/`,
+	`^/_next/static/`,
+}
+
+// cacheBusterParameter is the query parameter of URLs like /app.css?v=3e1f2a7c
+const cacheBusterParameter = "v"
+
+// WithFingerprintedAssets caches files forever when their path matches any of
+// the regular expressions or they are requested with a ?v=<hash> cache
+// buster. HTML pages are never fingerprinted. Without patterns
+// DefaultFingerprintPatterns are used.
+func WithFingerprintedAssets(patterns ...string) ConfigFunc {
+	return func(service *Service) error {
+		if len(patterns) == 0 {
+			patterns = DefaultFingerprintPatterns
+		}
+
+		service.fingerprints = nil
+		for _, pattern := range patterns {
+			expression, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+
+			service.fingerprints = append(service.fingerprints, expression)
+		}
+
+		return nil
+	}
+}
+
+// isFingerprinted reports whether the file served for the request can never
+// change.
+func (service *Service) isFingerprinted(r *http.Request, filePath string) bool {
+	if len(service.fingerprints) == 0 {
+		return false
+	}
+
+	// Pages have to stay revalidated for links to them to ever change
+	if mediaType, _, _ := mime.ParseMediaType(service.contentType(filePath, nil)); mediaType == "text/html" {
+		return false
+	}
+
+	if looksLikeHash(r.URL.Query().Get(cacheBusterParameter)) && !isRewritten(r) {
+		return true
+	}
+
+	filePath = "/" + strings.TrimPrefix(filePath, "/")
+	for _, expression := range service.fingerprints {
+		match := expression.FindStringSubmatch(filePath)
+		if match == nil {
+			continue
+		}
+
+		hashIndex := expression.SubexpIndex("hash")
+		if hashIndex < 0 || looksLikeHash(match[hashIndex]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// looksLikeHash tells hashes, which mix digits and letters, apart from words
+// such as "settings" or "20240101".
+func looksLikeHash(value string) bool {
+	digit, lower, upper, hex := false, false, false, true
+	for _, character := range value {
+		switch {
+		case character >= '0' && character <= '9':
+			digit = true
+		case character >= 'a' && character <= 'z':
+			lower = true
+			hex = hex && character <= 'f'
+		case character >= 'A' && character <= 'Z':
+			upper = true
+			hex = false
+		default:
+			hex = false
+		}
+	}
+
+	if !digit || !(lower || upper) {
+		return false
+	}
+
+	return hex || (lower && upper)
+}
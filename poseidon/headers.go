@@ -0,0 +1,290 @@
+package poseidon
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const headersFile = "_headers"
+
+// protectedHeaders frame the response and can't be changed by a _headers file
+var protectedHeaders = []string{"Connection", "Content-Encoding", "Content-Length", "Content-Range", "Transfer-Encoding"}
+
+// listHeaders hold comma separated lists, so the values of several rules can
+// be joined
+var listHeaders = []string{
+	"Access-Control-Allow-Headers",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Expose-Headers",
+	"Link",
+	"Permissions-Policy",
+	"Timing-Allow-Origin",
+	"Vary",
+	"X-Robots-Tag",
+}
+
+// headerRule attaches headers to the responses of paths matching its pattern.
+// Header values can use the captures of the pattern as :name.
+type headerRule struct {
+	host    string
+	pattern *regexp.Regexp
+	set     []headerValue
+	detach  []string
+}
+
+type headerValue struct {
+	Name  string
+	Value string
+}
+
+// parseHeaders reads a Netlify or Cloudflare style _headers file, where
+// indented "Name: value" and "! Name" lines follow their path.
+func parseHeaders(content string) ([]headerRule, []error) {
+	rules := []headerRule{}
+	errs := []error{}
+
+	var current *headerRule
+	for number, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		lineErr := func(err error) {
+			errs = append(errs, fmt.Errorf("line %d: %w", number+1, err))
+		}
+
+		// Paths start at the beginning of the line
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			rule, err := parseHeaderPath(trimmed)
+			if err != nil {
+				lineErr(err)
+				current = nil
+				continue
+			}
+
+			rules = append(rules, rule)
+			current = &rules[len(rules)-1]
+			continue
+		}
+
+		if current == nil {
+			lineErr(errors.New("header without a valid path"))
+			continue
+		}
+
+		if name, found := strings.CutPrefix(trimmed, "!"); found {
+			current.detach = append(current.detach, http.CanonicalHeaderKey(strings.TrimSpace(name)))
+			continue
+		}
+
+		name, value, found := strings.Cut(trimmed, ":")
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			lineErr(fmt.Errorf("invalid header: %s", trimmed))
+			continue
+		}
+
+		if containsFold(protectedHeaders, name) {
+			lineErr(fmt.Errorf("header can't be set: %s", name))
+			continue
+		}
+
+		current.set = append(current.set, headerValue{Name: name, Value: strings.TrimSpace(value)})
+	}
+
+	return rules, errs
+}
+
+func parseHeaderPath(value string) (headerRule, error) {
+	rule := headerRule{}
+
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		valueURL, err := url.Parse(value)
+		if err != nil {
+			return rule, err
+		}
+		rule.host = valueURL.Hostname()
+		value = valueURL.Path
+		if value == "" {
+			value = "/"
+		}
+	}
+
+	pattern, err := compileHeaderPathPattern(value)
+	if err != nil {
+		return rule, err
+	}
+	rule.pattern = pattern
+
+	return rule, nil
+}
+
+// headersFileState caches the parsed _headers file until it changes.
+type headersFileState struct {
+	// reloading lets a single request parse a changed file
+	reloading sync.Mutex
+	current   atomic.Pointer[headersSnapshot]
+}
+
+type headersSnapshot struct {
+	modTime time.Time
+	size    int64
+	rules   []headerRule
+}
+
+func (snapshot *headersSnapshot) matches(stat fs.FileInfo) bool {
+	return snapshot != nil && stat.ModTime().Equal(snapshot.modTime) && stat.Size() == snapshot.size
+}
+
+// WithHeadersFile attaches the headers of a Netlify or Cloudflare style
+// _headers file at the root of the file system. The file is read again when
+// it changes, invalid lines are logged and skipped, and the file itself is
+// never served.
+//
+// Headers from the file override the ones poseidon sets, such as
+// Cache-Control, except for Vary which is merged. When several paths match,
+// the values of list headers such as Link are joined and the later rule wins
+// for other headers.
+func WithHeadersFile() ConfigFunc {
+	return func(service *Service) error {
+		service.deniedPaths = append(service.deniedPaths, "/"+headersFile)
+		service.headersFile = &headersFileState{}
+
+		return nil
+	}
+}
+
+// headerRules returns the rules of the _headers file, reading it again if it
+// changed since the last request.
+func (service *Service) headerRules() []headerRule {
+	state := service.headersFile
+
+	stat, err := fs.Stat(service.fileSystem, headersFile)
+	if err != nil {
+		state.current.Store(nil)
+		return nil
+	}
+
+	if snapshot := state.current.Load(); snapshot.matches(stat) {
+		return snapshot.rules
+	}
+
+	state.reloading.Lock()
+	defer state.reloading.Unlock()
+
+	// Another request may have reloaded it in the meantime
+	snapshot := state.current.Load()
+	if snapshot.matches(stat) {
+		return snapshot.rules
+	}
+
+	content, err := fs.ReadFile(service.fileSystem, headersFile)
+	if err != nil {
+		log.Printf("%s: %v", headersFile, err)
+		if snapshot != nil {
+			return snapshot.rules
+		}
+		return nil
+	}
+
+	rules, errs := parseHeaders(string(content))
+	for _, err := range errs {
+		log.Printf("%s: %v", headersFile, err)
+	}
+
+	state.current.Store(&headersSnapshot{
+		modTime: stat.ModTime(),
+		size:    stat.Size(),
+		rules:   rules,
+	})
+
+	return rules
+}
+
+// customHeaders collects the headers of all rules matching the request.
+func (service *Service) customHeaders(r *http.Request) (http.Header, []string) {
+	set := http.Header{}
+	detach := []string{}
+
+	for _, rule := range service.headerRules() {
+		if rule.host != "" && !strings.EqualFold(rule.host, hostWithoutPort(r.Host)) {
+			continue
+		}
+
+		captures, found := matchPath(rule.pattern, r.URL.Path)
+		if !found {
+			continue
+		}
+
+		for _, header := range rule.set {
+			value := expandPlaceholders(header.Value, captures, placeholderPattern)
+			if existing := set.Get(header.Name); existing != "" && containsFold(listHeaders, header.Name) {
+				value = existing + ", " + value
+			}
+			set.Set(header.Name, value)
+		}
+		detach = append(detach, rule.detach...)
+	}
+
+	return set, detach
+}
+
+// headersResponseWriter applies the custom headers right before the response
+// starts, so they win over the ones set while handling the request.
+type headersResponseWriter struct {
+	http.ResponseWriter
+	set         http.Header
+	detach      []string
+	wroteHeader bool
+}
+
+func (w *headersResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+
+		header := w.Header()
+		for name, values := range w.set {
+			if name == "Vary" {
+				header.Add(name, strings.Join(values, ", "))
+				continue
+			}
+
+			header[name] = values
+		}
+		for _, name := range w.detach {
+			header.Del(name)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headersResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headersResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *headersResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,114 @@
+package poseidon
+
+import (
+	"path"
+	"strings"
+)
+
+var defaultAllowedHiddenPaths = []string{".well-known/"}
+
+// Editor backups and merge leftovers that should never be served
+var defaultDeniedPaths = []string{"*~", "#*#", "*.bak", "*.orig", "*.swp"}
+
+// WithHiddenFiles serves dotfiles and dot-directories, which are refused by
+// default.
+func WithHiddenFiles() ConfigFunc {
+	return func(service *Service) error {
+		service.hiddenFiles = true
+
+		return nil
+	}
+}
+
+// WithAllowedHiddenPaths replaces the list of hidden paths that are served
+// even though hidden files are refused (".well-known/" by default).
+func WithAllowedHiddenPaths(paths ...string) ConfigFunc {
+	return func(service *Service) error {
+		service.allowedHiddenPaths = paths
+
+		return nil
+	}
+}
+
+// WithDeniedPaths refuses paths matching any of the glob patterns. Patterns
+// without a slash match any path segment, patterns with a slash match the
+// whole path and patterns ending in a slash match everything below it.
+func WithDeniedPaths(patterns ...string) ConfigFunc {
+	return func(service *Service) error {
+		for _, pattern := range patterns {
+			if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+				return err
+			}
+		}
+
+		service.deniedPaths = append(service.deniedPaths, patterns...)
+
+		return nil
+	}
+}
+
+// isDenied reports whether the file system path must not be served.
+func (service *Service) isDenied(name string) bool {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return false
+	}
+
+	segments := strings.Split(name, "/")
+
+	for _, pattern := range service.deniedPaths {
+		if matchPathPattern(pattern, name, segments) {
+			return true
+		}
+	}
+
+	if service.hiddenFiles {
+		return false
+	}
+
+	// Hidden files below an allowed path are still refused
+	checked := segments
+	for _, allowed := range service.allowedHiddenPaths {
+		allowed = strings.Trim(allowed, "/")
+		if name == allowed || strings.HasPrefix(name, allowed+"/") {
+			checked = segments[strings.Count(allowed, "/")+1:]
+			break
+		}
+	}
+
+	for _, segment := range checked {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPathPattern(pattern string, name string, segments []string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	if prefix, found := strings.CutSuffix(pattern, "/"); found {
+		for i := range segments {
+			if matched, _ := path.Match(prefix, strings.Join(segments[:i+1], "/")); matched {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if !strings.Contains(pattern, "/") {
+		for _, segment := range segments {
+			if matched, _ := path.Match(pattern, segment); matched {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	matched, _ := path.Match(pattern, name)
+
+	return matched
+}
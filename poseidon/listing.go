@@ -0,0 +1,183 @@
+package poseidon
+
+import (
+	"cmp"
+	_ "embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+//go:embed templates/listing.go.html
+var listingTemplateBytes []byte
+
+var listingTemplate = template.Must(template.New("listing").Parse(string(listingTemplateBytes)))
+
+type directoryListing struct {
+	Path    string           `json:"path"`
+	Sort    string           `json:"sort"`
+	Order   string           `json:"order"`
+	Entries []directoryEntry `json:"entries"`
+}
+
+func (listing directoryListing) NextOrder(column string) string {
+	if listing.Sort == column && listing.Order == "asc" {
+		return "desc"
+	}
+
+	return "asc"
+}
+
+type directoryEntry struct {
+	Name     string    `json:"name"`
+	Href     string    `json:"href"`
+	IsDir    bool      `json:"is_dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// WithDirectoryListing renders an index of directories that have no index
+// file. Listings can be limited to the given path prefixes.
+func WithDirectoryListing(prefixes ...string) ConfigFunc {
+	return func(service *Service) error {
+		service.directoryListing = true
+		service.directoryListingPrefixes = []string{}
+		for _, prefix := range prefixes {
+			// Prefixes match whole path segments
+			prefix = strings.TrimSuffix("/"+strings.Trim(prefix, "/"), "/") + "/"
+			service.directoryListingPrefixes = append(service.directoryListingPrefixes, prefix)
+		}
+
+		return nil
+	}
+}
+
+func (service *Service) directoryListingAllowed(urlPath string) bool {
+	if !service.directoryListing || !strings.HasSuffix(urlPath, "/") {
+		return false
+	}
+
+	if len(service.directoryListingPrefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range service.directoryListingPrefixes {
+		if strings.HasPrefix(urlPath, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serveDirectoryListing renders the listing of the requested directory as
+// HTML or JSON. It returns false when no listing should be served.
+func (service *Service) serveDirectoryListing(w http.ResponseWriter, r *http.Request) bool {
+	if !service.directoryListingAllowed(r.URL.Path) {
+		return false
+	}
+
+	if service.refuseFileMethod(w, r) {
+		return true
+	}
+
+	directory := strings.Trim(r.URL.Path, "/")
+	if directory == "" {
+		directory = "."
+	}
+
+	dirEntries, err := fs.ReadDir(service.fileSystem, directory)
+	if err != nil {
+		return false
+	}
+
+	listing := directoryListing{
+		Path:    mountedPath(r, r.URL.Path),
+		Sort:    r.URL.Query().Get("sort"),
+		Order:   r.URL.Query().Get("order"),
+		Entries: []directoryEntry{},
+	}
+
+	for _, dirEntry := range dirEntries {
+		entryPath := path.Join(directory, dirEntry.Name())
+		if service.isDenied(entryPath) {
+			continue
+		}
+
+		// Stat through the file system, so symlinks the policy refuses are left out
+		info, err := fs.Stat(service.fileSystem, entryPath)
+		if err != nil {
+			continue
+		}
+
+		name := dirEntry.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+
+		listing.Entries = append(listing.Entries, directoryEntry{
+			Name:     name,
+			Href:     "./" + (&url.URL{Path: name}).EscapedPath(),
+			IsDir:    info.IsDir(),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+
+	sortDirectoryEntries(&listing)
+
+	if prefersJSON(r) {
+		RespondJSON(w, http.StatusOK, listing)
+		return true
+	}
+
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_ = listingTemplate.Execute(w, listing)
+	}
+
+	return true
+}
+
+func sortDirectoryEntries(listing *directoryListing) {
+	if !slices.Contains([]string{"name", "size", "modified"}, listing.Sort) {
+		listing.Sort = "name"
+	}
+
+	if listing.Order != "desc" {
+		listing.Order = "asc"
+	}
+
+	slices.SortStableFunc(listing.Entries, func(a directoryEntry, b directoryEntry) int {
+		// Directories are always listed first
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+
+		result := 0
+		switch listing.Sort {
+		case "size":
+			result = cmp.Compare(a.Size, b.Size)
+		case "modified":
+			result = a.Modified.Compare(b.Modified)
+		}
+		if result == 0 {
+			result = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+
+		if listing.Order == "desc" {
+			return -result
+		}
+
+		return result
+	})
+}
@@ -0,0 +1,99 @@
+package poseidon
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var staticMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+type handlerRoute struct {
+	prefix  string
+	handler http.Handler
+}
+
+// WithAllowedMethods lists additional methods in the Allow header and lets
+// them through to the redirect rules and the not found handlers. Files still
+// only answer GET and HEAD.
+func WithAllowedMethods(methods ...string) ConfigFunc {
+	return func(service *Service) error {
+		for _, method := range methods {
+			method = strings.ToUpper(method)
+			if !slices.Contains(service.allowedMethods, method) {
+				service.allowedMethods = append(service.allowedMethods, method)
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithHandler routes requests below the path prefix to the handler, with
+// their full path. Handlers take precedence over files and receive requests
+// of any method.
+func WithHandler(prefix string, handler http.Handler) ConfigFunc {
+	return func(service *Service) error {
+		service.handlerRoutes = append(service.handlerRoutes, handlerRoute{
+			prefix:  prefix,
+			handler: handler,
+		})
+
+		// Longest prefixes are matched first
+		slices.SortStableFunc(service.handlerRoutes, func(a handlerRoute, b handlerRoute) int {
+			return len(b.prefix) - len(a.prefix)
+		})
+
+		return nil
+	}
+}
+
+func (service *Service) routedHandler(r *http.Request) http.Handler {
+	for _, route := range service.handlerRoutes {
+		if hasPathPrefix(r.URL.Path, route.prefix) {
+			return route.handler
+		}
+	}
+
+	return nil
+}
+
+// hasPathPrefix matches prefixes on whole segments, so /api doesn't take
+// /apiary.
+func hasPathPrefix(urlPath string, prefix string) bool {
+	if strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(urlPath, prefix)
+	}
+
+	return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// checkMethod answers OPTIONS requests and rejects methods the static file
+// handler does not support. It returns true when the response was written.
+func (service *Service) checkMethod(w http.ResponseWriter, r *http.Request) bool {
+	switch {
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Allow", strings.Join(service.allowedMethods, ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	case slices.Contains(service.allowedMethods, r.Method):
+		return false
+	default:
+		w.Header().Set("Allow", strings.Join(service.allowedMethods, ", "))
+		service.ServeError(w, r, http.StatusMethodNotAllowed)
+		return true
+	}
+}
+
+// refuseFileMethod answers methods other than GET and HEAD with a 405, as
+// files can't handle them. It returns true when the response was written.
+func (service *Service) refuseFileMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return false
+	}
+
+	w.Header().Set("Allow", strings.Join(staticMethods, ", "))
+	service.ServeError(w, r, http.StatusMethodNotAllowed)
+
+	return true
+}
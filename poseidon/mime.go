@@ -0,0 +1,146 @@
+package poseidon
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// defaultMIMETypes is shipped with poseidon so content types don't depend on
+// the mime.types files of the host system.
+var defaultMIMETypes = map[string]string{
+	".aac":         "audio/aac",
+	".atom":        "application/atom+xml",
+	".avif":        "image/avif",
+	".bmp":         "image/bmp",
+	".cjs":         "text/javascript",
+	".css":         "text/css",
+	".csv":         "text/csv",
+	".eot":         "application/vnd.ms-fontobject",
+	".epub":        "application/epub+zip",
+	".flac":        "audio/flac",
+	".gif":         "image/gif",
+	".glb":         "model/gltf-binary",
+	".gltf":        "model/gltf+json",
+	".gz":          "application/gzip",
+	".htm":         "text/html",
+	".html":        "text/html",
+	".ico":         "image/x-icon",
+	".ics":         "text/calendar",
+	".jpeg":        "image/jpeg",
+	".jpg":         "image/jpeg",
+	".js":          "text/javascript",
+	".json":        "application/json",
+	".jsonld":      "application/ld+json",
+	".jxl":         "image/jxl",
+	".m3u8":        "application/vnd.apple.mpegurl",
+	".m4a":         "audio/mp4",
+	".map":         "application/json",
+	".md":          "text/markdown",
+	".mjs":         "text/javascript",
+	".mov":         "video/quicktime",
+	".mp3":         "audio/mpeg",
+	".mp4":         "video/mp4",
+	".mpd":         "application/dash+xml",
+	".oga":         "audio/ogg",
+	".ogg":         "audio/ogg",
+	".ogv":         "video/ogg",
+	".opus":        "audio/opus",
+	".otf":         "font/otf",
+	".pdf":         "application/pdf",
+	".png":         "image/png",
+	".rss":         "application/rss+xml",
+	".svg":         "image/svg+xml",
+	".tar":         "application/x-tar",
+	".tif":         "image/tiff",
+	".tiff":        "image/tiff",
+	".toml":        "application/toml",
+	".ttf":         "font/ttf",
+	".txt":         "text/plain",
+	".vtt":         "text/vtt",
+	".wasm":        "application/wasm",
+	".wav":         "audio/wav",
+	".webm":        "video/webm",
+	".webmanifest": "application/manifest+json",
+	".webp":        "image/webp",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".xhtml":       "application/xhtml+xml",
+	".xml":         "application/xml",
+	".yaml":        "application/yaml",
+	".yml":         "application/yaml",
+	".zip":         "application/zip",
+}
+
+// sniffLength is the amount of content http.DetectContentType looks at
+const sniffLength = 512
+
+// WithMIMETypes adds or overrides content types by file extension, for
+// example {".glb": "model/gltf-binary"}.
+func WithMIMETypes(types map[string]string) ConfigFunc {
+	return func(service *Service) error {
+		for extension, contentType := range types {
+			if _, _, err := mime.ParseMediaType(contentType); err != nil {
+				return err
+			}
+
+			extension = strings.ToLower(extension)
+			if !strings.HasPrefix(extension, ".") {
+				extension = "." + extension
+			}
+
+			service.mimeTypes[extension] = contentType
+		}
+
+		return nil
+	}
+}
+
+// contentType determines the content type by extension and falls back to
+// sniffing the content of seekable files.
+func (service *Service) contentType(name string, file fs.File) string {
+	if contentType, ok := service.mimeTypes[strings.ToLower(path.Ext(name))]; ok {
+		return withCharset(contentType)
+	}
+
+	seeker, ok := file.(io.ReadSeeker)
+	if !ok {
+		return "application/octet-stream"
+	}
+
+	buffer := make([]byte, sniffLength)
+	n, _ := io.ReadFull(seeker, buffer)
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return "application/octet-stream"
+	}
+
+	return http.DetectContentType(buffer[:n])
+}
+
+// withCharset adds the UTF-8 charset to textual types that don't name one.
+func withCharset(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["charset"] != "" {
+		return contentType
+	}
+
+	textual := strings.HasPrefix(mediaType, "text/")
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml":
+		textual = true
+	}
+	if strings.HasPrefix(mediaType, "application/") && (strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")) {
+		textual = true
+	}
+
+	if !textual {
+		return contentType
+	}
+
+	params["charset"] = "utf-8"
+
+	return mime.FormatMediaType(mediaType, params)
+}
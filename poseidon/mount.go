@@ -0,0 +1,68 @@
+package poseidon
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type mountContextKey struct{}
+
+// Mount serves the handler under the path prefix. Like http.StripPrefix, the
+// handler sees paths without the prefix, but services also know where they
+// are mounted, so their redirects, directory listings and rendered pages
+// point below the prefix. Requests for the prefix without a trailing slash
+// are redirected to it with one, and mounts can be nested.
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/", site)
+//	mux.Handle("/app/", poseidon.Mount("/app", app))
+//	mux.Handle("/docs/", poseidon.Mount("/docs", docs))
+func Mount(prefix string, handler http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urlPath, found := strings.CutPrefix(r.URL.Path, prefix)
+		if !found || urlPath != "" && !strings.HasPrefix(urlPath, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		rawPath, found := strings.CutPrefix(r.URL.RawPath, prefix)
+		if r.URL.RawPath != "" && !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		if urlPath == "" {
+			redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		mounted := r.WithContext(context.WithValue(r.Context(), mountContextKey{}, MountPrefix(r)+prefix))
+		mountedURL := *r.URL
+		mountedURL.Path = urlPath
+		mountedURL.RawPath = rawPath
+		mounted.URL = &mountedURL
+
+		handler.ServeHTTP(w, mounted)
+	})
+}
+
+// MountPrefix returns the path prefix the request was mounted under with
+// Mount, which is empty at the root.
+func MountPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(mountContextKey{}).(string)
+
+	return prefix
+}
+
+// mountedPath turns a path of the service into the path the client sees.
+// Relative paths and URLs are left alone.
+func mountedPath(r *http.Request, urlPath string) string {
+	if !strings.HasPrefix(urlPath, "/") || strings.HasPrefix(urlPath, "//") {
+		return urlPath
+	}
+
+	return MountPrefix(r) + urlPath
+}
@@ -0,0 +1,135 @@
+package poseidon
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+type qualityValue struct {
+	Value   string
+	Quality float64
+}
+
+// parseQualityList parses headers such as Accept and Accept-Encoding into
+// their values and q-values. Parameters other than "q" are dropped.
+func parseQualityList(header string) []qualityValue {
+	values := []qualityValue{}
+	for _, part := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(part, ";")
+		value = strings.ToLower(textproto.TrimString(value))
+		if value == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, rawQuality, found := strings.Cut(param, "=")
+			if !found || !strings.EqualFold(textproto.TrimString(key), "q") {
+				continue
+			}
+
+			parsedQuality, err := strconv.ParseFloat(textproto.TrimString(rawQuality), 64)
+			if err != nil || parsedQuality < 0 || parsedQuality > 1 {
+				parsedQuality = 0
+			}
+			quality = parsedQuality
+		}
+
+		values = append(values, qualityValue{Value: value, Quality: quality})
+	}
+
+	return values
+}
+
+// encodingQuality returns the q-value the Accept-Encoding header assigns to
+// the given content coding, following RFC 9110 section 12.5.3.
+func encodingQuality(acceptEncoding []qualityValue, encoding string) float64 {
+	wildcard := -1.0
+	for _, value := range acceptEncoding {
+		switch value.Value {
+		case encoding:
+			return value.Quality
+		case "*":
+			wildcard = value.Quality
+		}
+	}
+
+	if wildcard >= 0 {
+		return wildcard
+	}
+
+	// Identity is always acceptable unless explicitly refused
+	if encoding == "identity" {
+		return 1
+	}
+
+	return 0
+}
+
+// negotiateEncoding picks the best content coding out of the available ones
+// (in server preference order). An empty string means identity.
+func negotiateEncoding(header string, available []string) string {
+	acceptEncoding := parseQualityList(header)
+
+	best := ""
+	bestQuality := 0.0
+	for _, encoding := range available {
+		quality := encodingQuality(acceptEncoding, encoding)
+		if quality > bestQuality {
+			best = encoding
+			bestQuality = quality
+		}
+	}
+
+	// Prefer identity if the client explicitly likes it better than any coding
+	for _, value := range acceptEncoding {
+		if value.Value == "identity" && value.Quality > bestQuality {
+			return ""
+		}
+	}
+
+	return best
+}
+
+// mediaTypeQuality returns the q-value the Accept header assigns to the
+// media type, taking the most specific matching range into account.
+func mediaTypeQuality(accept []qualityValue, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+
+	quality := 0.0
+	specificity := -1
+	for _, value := range accept {
+		valueSpecificity := -1
+		switch value.Value {
+		case mediaType:
+			valueSpecificity = 2
+		case mainType + "/*":
+			valueSpecificity = 1
+		case "*/*":
+			valueSpecificity = 0
+		}
+
+		if valueSpecificity > specificity {
+			quality = value.Quality
+			specificity = valueSpecificity
+		}
+	}
+
+	return quality
+}
+
+// prefersJSON reports whether the client asked for JSON over HTML.
+func prefersJSON(r *http.Request) bool {
+	accept := parseQualityList(r.Header.Get("Accept"))
+	jsonQuality := mediaTypeQuality(accept, "application/json")
+
+	return jsonQuality > 0 && jsonQuality > mediaTypeQuality(accept, "text/html")
+}
+
+// acceptsHTML reports whether the client explicitly asked for HTML, as
+// browsers do for navigations. A bare */* does not count.
+func acceptsHTML(r *http.Request) bool {
+	return MatchAccept("text/html")(r)
+}
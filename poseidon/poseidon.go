@@ -2,8 +2,10 @@ package poseidon
 
 import (
 	"io/fs"
+	"maps"
 	"net/http"
-	"strings"
+	"regexp"
+	"slices"
 )
 
 func New(
@@ -11,31 +13,69 @@ func New(
 	configFuncs ...ConfigFunc,
 ) (*Service, error) {
 	service := &Service{
-		fileSystem:      fileSystem,
-		index:           "index.html",
-		notFoundHandler: http.NotFoundHandler(),
-		middlewares:     Middlewares{},
+		fileSystem:     fileSystem,
+		index:          "index.html",
+		indexes:        []string{"index.html"},
+		middlewares:    Middlewares{},
+		allowedMethods: slices.Clone(staticMethods),
+
+		compressionMinSize:  defaultCompressionMinSize,
+		trailingSlashStatus: http.StatusTemporaryRedirect,
+		allowedHiddenPaths:  slices.Clone(defaultAllowedHiddenPaths),
+		deniedPaths:         slices.Clone(defaultDeniedPaths),
+		mimeTypes:           maps.Clone(defaultMIMETypes),
+		errorPages:          map[int]string{},
 	}
 
+	service.notFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service.ServeError(w, r, http.StatusNotFound)
+	})
+
 	for _, configFunc := range configFuncs {
 		if err := configFunc(service); err != nil {
 			return nil, err
 		}
 	}
 
-	service.handler = service.middlewares.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	service.handler = service.recoverer(service.middlewares.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		service.internalServeHTTP(w, r)
-	}))
+	})))
 
 	return service, nil
 }
 
 type Service struct {
-	fileSystem      fs.FS
-	index           string
-	notFoundHandler http.Handler
-	middlewares     Middlewares
-	handler         http.Handler
+	fileSystem                fs.FS
+	index                     string
+	notFoundHandler           http.Handler
+	middlewares               Middlewares
+	handler                   http.Handler
+	precompressed             []string
+	precompressedDirectAccess bool
+	compression               bool
+	compressionMinSize        int
+	allowedMethods            []string
+	handlerRoutes             []handlerRoute
+	directoryListing          bool
+	directoryListingPrefixes  []string
+	indexes                   []string
+	trailingSlash             TrailingSlashPolicy
+	trailingSlashStatus       int
+	cleanURLs                 bool
+	cleanURLRedirects         bool
+	hiddenFiles               bool
+	allowedHiddenPaths        []string
+	deniedPaths               []string
+	mimeTypes                 map[string]string
+	errorPages                map[int]string
+	securityHeaders           *SecurityHeaders
+	fallbacks                 []fallbackRule
+	fingerprints              []*regexp.Regexp
+	cacheRules                []cacheRule
+	notFoundCachePolicy       *CachePolicy
+	fallbackCachePolicy       *CachePolicy
+	redirectRules             []redirectRule
+	headersFile               *headersFileState
 }
 
 func (service *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -43,30 +83,74 @@ func (service *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (service *Service) internalServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	path = strings.TrimPrefix(path, "/")
-	if strings.HasSuffix(path, "/") || path == "" {
-		path += service.index
+	// Rewrites already got the headers of the path that was requested
+	if service.headersFile != nil && !isRewritten(r) {
+		if set, detach := service.customHeaders(r); len(set) > 0 || len(detach) > 0 {
+			w = &headersResponseWriter{ResponseWriter: w, set: set, detach: detach}
+		}
+	}
+
+	if handler := service.routedHandler(r); handler != nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	if service.checkMethod(w, r) {
+		return
+	}
+
+	// Refused paths look exactly like missing ones
+	if service.isDenied(r.URL.Path) {
+		service.serveNotFound(w, r)
+		return
+	}
+
+	if service.serveRedirectRules(w, r) {
+		return
+	}
+
+	resolution := service.resolve(r)
+	if resolution.redirect != "" {
+		redirect(w, r, resolution.redirect, resolution.status)
+		return
+	}
+
+	path := resolution.path
+	if path == "" || service.isDenied(path) || service.isPrecompressedSibling(path) {
+		if service.serveDirectoryListing(w, r) {
+			return
+		}
+
+		service.serveNotFound(w, r)
+		return
 	}
 
 	file, err := service.fileSystem.Open(path)
 	if err != nil {
-		doNotCache(w)
-		service.notFoundHandler.ServeHTTP(w, r)
+		service.serveNotFound(w, r)
 		return
 	}
 
-	// If directory, add trailing slash and retry
-	if info, err := file.Stat(); err != nil {
-		panic(err)
-	} else if info.IsDir() && !strings.HasSuffix(r.URL.Path, "/") {
-		doNotCache(w)
-		http.Redirect(w, r, r.URL.Path+"/", http.StatusTemporaryRedirect)
+	if _, err := file.Stat(); err != nil {
+		_ = file.Close()
+		service.ServeError(w, r, http.StatusInternalServerError)
 		return
 	}
 
+	// Fingerprinted files never change, whatever the cache policy says
+	if service.isFingerprinted(r, path) {
+		cacheForever(w)
+	}
+
 	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		writeFile(w, file, http.StatusOK)
+		if variant, encoding := service.openPrecompressed(w, r, path); variant != nil {
+			_ = file.Close()
+			w.Header().Set("Content-Encoding", encoding)
+			service.writeFile(w, r, variant, path, http.StatusOK)
+			return
+		}
+
+		service.writeFile(w, r, file, path, http.StatusOK)
 	})
 
 	handler.ServeHTTP(w, r)
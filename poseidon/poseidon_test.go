@@ -1,14 +1,32 @@
 package poseidon_test
 
 import (
+	"bufio"
 	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"github.com/lunagic/poseidon/poseidon"
 )
 
@@ -19,6 +37,7 @@ type TestCase struct {
 	RequestHeaders     map[string]string
 	ExpectedBody       string
 	ConfigFuncs        []poseidon.ConfigFunc
+	SkipBodyCheck      bool
 }
 
 func TestMiddlewareOrder(t *testing.T) {
@@ -266,7 +285,2882 @@ func TestFolderIndexWithTrailingSlash(t *testing.T) {
 	})
 }
 
-func testService(t *testing.T, testCase TestCase) {
+func TestConditionalGetIfNoneMatch(t *testing.T) {
+	recorder := testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Disallow: *\n",
+	})
+
+	etag := recorder.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Missing ETag header")
+	}
+
+	if recorder.Header().Get("Last-Modified") == "" {
+		t.Fatal("Missing Last-Modified header")
+	}
+
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"If-None-Match": etag,
+		},
+		ExpectedStatusCode: http.StatusNotModified,
+		ExpectedBody:       "",
+		ExpectedHeaders: map[string]string{
+			"ETag":         etag,
+			"Content-Type": "",
+		},
+	})
+}
+
+func TestConditionalGetIfModifiedSince(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+		},
+		ExpectedStatusCode: http.StatusNotModified,
+		ExpectedBody:       "",
+	})
+}
+
+func TestConditionalGetIfMatchFailed(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"If-Match": "\"does-not-match\"",
+		},
+		ExpectedStatusCode: http.StatusPreconditionFailed,
+		ExpectedBody:       "",
+	})
+}
+
+func TestConditionalGetIfUnmodifiedSinceFailed(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"If-Unmodified-Since": time.Unix(0, 0).UTC().Format(http.TimeFormat),
+		},
+		ExpectedStatusCode: http.StatusPreconditionFailed,
+		ExpectedBody:       "",
+	})
+}
+
+func TestRangeSingle(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Range": "bytes=0-7",
+		},
+		ExpectedStatusCode: http.StatusPartialContent,
+		ExpectedBody:       "Disallow",
+		ExpectedHeaders: map[string]string{
+			"Content-Range":  "bytes 0-7/12",
+			"Content-Length": "8",
+			"Content-Type":   "text/plain; charset=utf-8",
+			"Accept-Ranges":  "bytes",
+		},
+	})
+}
+
+func TestRangeSuffix(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Range": "bytes=-2",
+		},
+		ExpectedStatusCode: http.StatusPartialContent,
+		ExpectedBody:       "*\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Range": "bytes 10-11/12",
+		},
+	})
+}
+
+func TestRangeMultipart(t *testing.T) {
+	recorder := testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Range": "bytes=0-3,8-",
+		},
+		ExpectedStatusCode: http.StatusPartialContent,
+		ExpectedBody:       "",
+		SkipBodyCheck:      true,
+	})
+
+	mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mediaType != "multipart/byteranges" {
+		t.Fatalf("Unexpected Content-Type, Got: %s", mediaType)
+	}
+
+	if recorder.Header().Get("Content-Length") != strconv.Itoa(recorder.Body.Len()) {
+		t.Fatalf("Unexpected Content-Length, Got: %s, Expected: %d", recorder.Header().Get("Content-Length"), recorder.Body.Len())
+	}
+
+	reader := multipart.NewReader(recorder.Body, params["boundary"])
+	for _, expected := range []string{"Disa", ": *\n"} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(body) != expected {
+			t.Fatalf("Unexpected Part, Got: %q, Expected: %q", body, expected)
+		}
+	}
+}
+
+func TestRangeNotSatisfiable(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Range": "bytes=100-",
+		},
+		ExpectedStatusCode: http.StatusRequestedRangeNotSatisfiable,
+		ExpectedBody:       "",
+		ExpectedHeaders: map[string]string{
+			"Content-Range": "bytes */12",
+		},
+	})
+}
+
+func TestRangeIfRangeMismatch(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Range":    "bytes=0-3",
+			"If-Range": "\"stale\"",
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Disallow: *\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Length": "12",
+		},
+	})
+}
+
+func TestRangeWithGZipCompression(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Range":           "bytes=0-3",
+			"Accept-Encoding": "gzip",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithGZipCompression(),
+		},
+		ExpectedStatusCode: http.StatusPartialContent,
+		ExpectedBody:       "Disa",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "",
+		},
+	})
+}
+
+func TestPrecompressedFilesPreferred(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/app.js",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip, br",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithPrecompressedFiles(),
+			poseidon.WithGZipCompression(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		SkipBodyCheck:      true,
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "br",
+			"Content-Type":     "text/javascript; charset=utf-8",
+			"Vary":             "Accept-Encoding",
+		},
+	})
+}
+
+func TestPrecompressedFilesQualityValues(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/app.js",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip;q=1, br;q=0.5",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithPrecompressedFiles(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "console.log(\"hello\");\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "gzip",
+			"Content-Type":     "text/javascript; charset=utf-8",
+		},
+	})
+}
+
+func TestPrecompressedFilesIdentity(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/app.js",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "br;q=0, gzip;q=0",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithPrecompressedFiles(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "console.log(\"hello\");\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "",
+			"Vary":             "Accept-Encoding",
+		},
+	})
+}
+
+func TestPrecompressedFilesNotDirectlyRequestable(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/app.js.gz",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithPrecompressedFiles(),
+		},
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+	})
+}
+
+func TestPrecompressedFilesDirectAccess(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/app.js.br",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithPrecompressedFiles(),
+			poseidon.WithDirectPrecompressedFileAccess(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "not really brotli\n",
+	})
+}
+
+func TestCompressionNegotiatesBrotli(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip, deflate, br, zstd",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCompression(),
+			poseidon.WithCompressionMinSize(0),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Hello there.\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "br",
+			"Content-Length":   "",
+			"Vary":             "Accept-Encoding",
+		},
+	})
+}
+
+func TestCompressionQualityValues(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "br;q=0.1, zstd;q=0.5, gzip;q=0",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCompression(),
+			poseidon.WithCompressionMinSize(0),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Hello there.\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "zstd",
+		},
+	})
+}
+
+func TestCompressionRefused(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip;q=0",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithGZipCompression(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Hello there.\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "",
+			"Content-Length":   "13",
+			"Vary":             "Accept-Encoding",
+		},
+	})
+}
+
+func TestCompressionBelowMinimumSize(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCompression(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Hello there.\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "",
+			"Content-Length":   "13",
+		},
+	})
+}
+
+func TestCompressionUnknownLengthBuffered(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCompression(),
+			poseidon.WithCompressionMinSize(16),
+			poseidon.WithMiddleware(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "text/plain")
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write([]byte(strings.Repeat("a", 10)))
+					_, _ = w.Write([]byte(strings.Repeat("b", 10)))
+				})
+			}),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       strings.Repeat("a", 10) + strings.Repeat("b", 10),
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "gzip",
+		},
+	})
+}
+
+func TestCompressionHead(t *testing.T) {
+	headers := map[string]http.Header{}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				method, "/robots.txt",
+				nil,
+			),
+			RequestHeaders: map[string]string{
+				"Accept-Encoding": "gzip",
+			},
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithCompression(),
+				poseidon.WithCompressionMinSize(0),
+			},
+			ExpectedStatusCode: http.StatusOK,
+			SkipBodyCheck:      true,
+			ExpectedHeaders: map[string]string{
+				"Content-Encoding": "gzip",
+				"Content-Length":   "",
+				"Accept-Ranges":    "",
+			},
+		})
+
+		if method == http.MethodHead && recorder.Body.Len() != 0 {
+			t.Fatalf("Unexpected body for HEAD: %q", recorder.Body.String())
+		}
+		headers[method] = recorder.Header()
+	}
+
+	if headers[http.MethodGet].Get("ETag") != headers[http.MethodHead].Get("ETag") {
+		t.Fatalf("Unexpected ETag for HEAD, Got: %s, Expected: %s", headers[http.MethodHead].Get("ETag"), headers[http.MethodGet].Get("ETag"))
+	}
+}
+
+func TestCompressionPanic(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCompression(),
+			poseidon.WithMiddleware(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "text/html")
+					_, _ = w.Write([]byte("<p>Partial"))
+					panic("something went wrong")
+				})
+			}),
+		},
+		ExpectedStatusCode: http.StatusInternalServerError,
+		ExpectedBody:       "500 internal server error\n",
+	})
+}
+
+func TestCompressionSkipsNotModified(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding":   "gzip",
+			"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCompression(),
+			poseidon.WithCompressionMinSize(0),
+		},
+		ExpectedStatusCode: http.StatusNotModified,
+		ExpectedBody:       "",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "",
+			"Vary":             "Accept-Encoding",
+		},
+	})
+}
+
+func TestCompressionSkipsRedirects(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCompression(),
+			poseidon.WithCompressionMinSize(0),
+		},
+		ExpectedStatusCode: http.StatusTemporaryRedirect,
+		ExpectedBody:       "<a href=\"/folder/\">Temporary Redirect</a>.\n\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Encoding": "",
+		},
+	})
+}
+
+func TestHeadRequest(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodHead, "/robots.txt",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "",
+		ExpectedHeaders: map[string]string{
+			"Content-Type":   "text/plain; charset=utf-8",
+			"Content-Length": "12",
+		},
+	})
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodPost, "/robots.txt",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusMethodNotAllowed,
+		ExpectedBody:       "405 method not allowed\n",
+		ExpectedHeaders: map[string]string{
+			"Allow": "GET, HEAD, OPTIONS",
+		},
+	})
+}
+
+func TestOptionsRequest(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodOptions, "/robots.txt",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithAllowedMethods(http.MethodPost),
+		},
+		ExpectedStatusCode: http.StatusNoContent,
+		ExpectedBody:       "",
+		ExpectedHeaders: map[string]string{
+			"Allow": "GET, HEAD, OPTIONS, POST",
+		},
+	})
+}
+
+func TestAllowedMethodsFile(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodPost, "/robots.txt",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithAllowedMethods(http.MethodPost),
+		},
+		ExpectedStatusCode: http.StatusMethodNotAllowed,
+		ExpectedBody:       "405 method not allowed\n",
+		ExpectedHeaders: map[string]string{
+			"Allow": "GET, HEAD, OPTIONS",
+		},
+	})
+}
+
+func TestAllowedMethodsNotFoundHandler(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodPost, "/contact",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "text/html",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithAllowedMethods(http.MethodPost),
+			poseidon.WithCustomNotFoundHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(r.Method + " " + r.URL.Path))
+			})),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "POST /contact",
+	})
+}
+
+func TestHandlerMethods(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodDelete, "/api/items/1",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithHandler("/api/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(r.Method + " " + r.URL.Path))
+			})),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "DELETE /api/items/1",
+	})
+}
+
+func TestHandlerFlush(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("Expected the response writer to be a Flusher")
+		}
+
+		_, _ = io.WriteString(w, "data: hello\n\n")
+		flusher.Flush()
+	})
+
+	for _, configFuncs := range [][]poseidon.ConfigFunc{
+		{},
+		{poseidon.WithSecurityHeaders(poseidon.DefaultSecurityHeaders())},
+		{poseidon.WithHeadersFile()},
+	} {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, "/events",
+				nil,
+			),
+			ConfigFuncs:        append(configFuncs, poseidon.WithHandler("/events", handler)),
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedBody:       "data: hello\n\n",
+		})
+
+		if !recorder.Flushed {
+			t.Fatal("Expected the response to be flushed")
+		}
+	}
+}
+
+func TestDirectoryListingDisabled(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/downloads/",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+	})
+}
+
+func TestDirectoryListingHTML(t *testing.T) {
+	recorder := testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/downloads/",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithDirectoryListing("/downloads/"),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		SkipBodyCheck:      true,
+		ExpectedHeaders: map[string]string{
+			"Content-Type": "text/html; charset=utf-8",
+		},
+	})
+
+	body := recorder.Body.String()
+	for _, expected := range []string{"Index of /downloads/", "href=\"./report.txt\"", "href=\"./archive/\""} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Missing %q in listing: %s", expected, body)
+		}
+	}
+
+	if strings.Contains(body, ".hidden") {
+		t.Fatalf("Hidden file listed: %s", body)
+	}
+}
+
+func TestDirectoryListingJSON(t *testing.T) {
+	recorder := testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/downloads/?sort=name&order=desc",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "application/json",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithDirectoryListing(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		SkipBodyCheck:      true,
+		ExpectedHeaders: map[string]string{
+			"Content-Type": "application/json",
+		},
+	})
+
+	listing := struct {
+		Path    string `json:"path"`
+		Entries []struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		} `json:"entries"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Name)
+	}
+
+	if strings.Join(names, ",") != "archive/,report.txt" {
+		t.Fatalf("Unexpected entries: %v", names)
+	}
+
+	if listing.Entries[1].Size != 7 {
+		t.Fatalf("Unexpected size: %d", listing.Entries[1].Size)
+	}
+}
+
+func TestDirectoryListingOutsidePrefix(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/_next/",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithDirectoryListing("/downloads/"),
+		},
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+	})
+}
+
+func TestDirectoryListingPrefixSegments(t *testing.T) {
+	service, err := poseidon.New(fstest.MapFS{
+		"downloads/file.txt":         {Data: []byte("file\n")},
+		"downloads-private/key.txt":  {Data: []byte("key\n")},
+		"downloads/nested/other.txt": {Data: []byte("other\n")},
+	}, poseidon.WithDirectoryListing("/downloads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expectedStatusCode := range map[string]int{
+		"/downloads/":         http.StatusOK,
+		"/downloads/nested/":  http.StatusOK,
+		"/downloads-private/": http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		service.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if recorder.Code != expectedStatusCode {
+			t.Fatalf("Unexpected Status Code for %s, Got: %d, Expected: %d", path, recorder.Code, expectedStatusCode)
+		}
+	}
+}
+
+func TestCleanURLs(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/about",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCleanURLs(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "About us.\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Type": "text/html; charset=utf-8",
+		},
+	})
+}
+
+func TestCleanURLRedirects(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/about.html?ref=nav",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCleanURLRedirects(),
+		},
+		ExpectedStatusCode: http.StatusPermanentRedirect,
+		ExpectedBody:       "<a href=\"/about?ref=nav\">Permanent Redirect</a>.\n\n",
+		ExpectedHeaders: map[string]string{
+			"Location": "/about?ref=nav",
+		},
+	})
+}
+
+func TestCleanURLRedirectsIndex(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder/index.html",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCleanURLRedirects(),
+		},
+		ExpectedStatusCode: http.StatusPermanentRedirect,
+		ExpectedBody:       "<a href=\"/folder/\">Permanent Redirect</a>.\n\n",
+	})
+}
+
+func TestCleanURLRedirectsShadowedByDirectory(t *testing.T) {
+	service, err := poseidon.New(fstest.MapFS{
+		"docs.html":       {Data: []byte("docs page\n")},
+		"docs/index.html": {Data: []byte("docs index\n")},
+	}, poseidon.WithCleanURLRedirects())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	service.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/docs.html", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected Status Code, Got: %d, Expected: %d", recorder.Code, http.StatusOK)
+	}
+
+	if recorder.Body.String() != "docs page\n" {
+		t.Fatalf("Unexpected Body: %q", recorder.Body.String())
+	}
+}
+
+func TestCleanURLRedirectsClientSideRouting(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/foobar",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"accept": "text/html",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCleanURLRedirects(),
+			poseidon.WithClientSideRouting(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Hello there.\n",
+	})
+}
+
+func TestIndexCandidates(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/legacy/",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithCustomIndex("index.html", "index.htm"),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Legacy index.\n",
+	})
+}
+
+func TestTrailingSlashAdd(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder?page=2",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithTrailingSlash(poseidon.TrailingSlashAdd),
+		},
+		ExpectedStatusCode: http.StatusPermanentRedirect,
+		ExpectedBody:       "<a href=\"/folder/?page=2\">Permanent Redirect</a>.\n\n",
+	})
+}
+
+func TestTrailingSlashStrip(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder/",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithTrailingSlash(poseidon.TrailingSlashStrip),
+		},
+		ExpectedStatusCode: http.StatusPermanentRedirect,
+		ExpectedBody:       "<a href=\"/folder\">Permanent Redirect</a>.\n\n",
+	})
+
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithTrailingSlash(poseidon.TrailingSlashStrip),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "This is in a folder.\n",
+	})
+}
+
+func TestRedirectsStayOnHost(t *testing.T) {
+	testCases := []struct {
+		Path             string
+		ConfigFuncs      []poseidon.ConfigFunc
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"//folder", nil, http.StatusTemporaryRedirect, "/folder/"},
+		{"//folder/", []poseidon.ConfigFunc{poseidon.WithTrailingSlash(poseidon.TrailingSlashStrip)}, http.StatusPermanentRedirect, "/folder"},
+		{"//about.html", []poseidon.ConfigFunc{poseidon.WithCleanURLRedirects()}, http.StatusPermanentRedirect, "/about"},
+	}
+
+	for _, testCase := range testCases {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, testCase.Path,
+				nil,
+			),
+			ConfigFuncs:        testCase.ConfigFuncs,
+			ExpectedStatusCode: testCase.ExpectedStatus,
+			SkipBodyCheck:      true,
+		})
+
+		if location := recorder.Header().Get("Location"); location != testCase.ExpectedLocation {
+			t.Fatalf("Unexpected Location for %s, Got: %s, Expected: %s", testCase.Path, location, testCase.ExpectedLocation)
+		}
+	}
+}
+
+func TestTrailingSlashIgnore(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithTrailingSlash(poseidon.TrailingSlashIgnore),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "This is in a folder.\n",
+	})
+}
+
+func TestHiddenFilesRefused(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/.env",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+	})
+}
+
+func TestHiddenFilesWellKnownAllowed(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/.well-known/security.txt",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Contact: mailto:security@example.com\n",
+	})
+}
+
+func TestHiddenFilesBelowWellKnownRefused(t *testing.T) {
+	for _, path := range []string{"/.well-known/.env", "/.well-known/.git/config"} {
+		testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, path,
+				nil,
+			),
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedBody:       "404 page not found\n",
+		})
+	}
+}
+
+func TestHiddenFilesEnabled(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/.env",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithHiddenFiles(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "SECRET=1\n",
+	})
+}
+
+func TestEditorBackupsRefused(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt~",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+	})
+}
+
+func TestDeniedPaths(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/_next/assets.css",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithDeniedPaths("*.css"),
+		},
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+	})
+
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder/",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithDeniedPaths("folder/"),
+		},
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+	})
+}
+
+func TestSymlinkPolicy(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, directory := range []string{root, outside} {
+		if err := os.Mkdir(directory, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("inside\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("index.html", filepath.Join(root, "inside.html")); err != nil {
+		t.Skip("symlinks are not supported: ", err)
+	}
+
+	if err := os.Symlink("../outside/secret.txt", filepath.Join(root, "relative.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "absolute")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join(root, "index.html"), filepath.Join(root, "absolute-inside.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testCase := range []struct {
+		Policy             poseidon.SymlinkPolicy
+		Path               string
+		ExpectedStatusCode int
+	}{
+		{poseidon.SymlinkFollow, "/relative.txt", http.StatusOK},
+		{poseidon.SymlinkFollow, "/absolute/secret.txt", http.StatusOK},
+		{poseidon.SymlinkWithinRoot, "/inside.html", http.StatusOK},
+		{poseidon.SymlinkWithinRoot, "/relative.txt", http.StatusNotFound},
+		{poseidon.SymlinkWithinRoot, "/absolute/secret.txt", http.StatusNotFound},
+		{poseidon.SymlinkWithinRoot, "/absolute-inside.html", http.StatusOK},
+		{poseidon.SymlinkDeny, "/", http.StatusOK},
+		{poseidon.SymlinkDeny, "/inside.html", http.StatusNotFound},
+	} {
+		service, err := poseidon.New(poseidon.DirFS(root), poseidon.WithSymlinkPolicy(testCase.Policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		service.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, testCase.Path, nil))
+
+		if recorder.Code != testCase.ExpectedStatusCode {
+			t.Fatalf("Unexpected Status Code for %s with %s, Got: %d, Expected: %d", testCase.Path, testCase.Policy, recorder.Code, testCase.ExpectedStatusCode)
+		}
+	}
+}
+
+func TestSymlinkPolicyDirectoryListing(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(base, "secret.txt"), []byte("secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "public.txt"), []byte("public\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("../secret.txt", filepath.Join(root, "secret.txt")); err != nil {
+		t.Skip("symlinks are not supported: ", err)
+	}
+
+	service, err := poseidon.New(
+		poseidon.DirFS(root),
+		poseidon.WithSymlinkPolicy(poseidon.SymlinkWithinRoot),
+		poseidon.WithDirectoryListing(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Accept", "application/json")
+	service.ServeHTTP(recorder, request)
+
+	listing := struct {
+		Entries []struct {
+			Name string `json:"name"`
+		} `json:"entries"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listing.Entries) != 1 || listing.Entries[0].Name != "public.txt" {
+		t.Fatalf("Unexpected entries: %+v", listing.Entries)
+	}
+}
+
+func TestSymlinkPolicyUnsupportedFileSystem(t *testing.T) {
+	if _, err := poseidon.New(struct{ fs.FS }{fstest.MapFS{}}, poseidon.WithSymlinkPolicy(poseidon.SymlinkDeny)); err == nil {
+		t.Fatal("Expected an error for a file system without symlink support")
+	}
+}
+
+func TestContentTypeBuiltIn(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/site.webmanifest",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "{\"name\":\"poseidon\"}\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Type":           "application/manifest+json; charset=utf-8",
+			"X-Content-Type-Options": "nosniff",
+		},
+	})
+}
+
+func TestContentTypeSniffed(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/NOTES",
+			nil,
+		),
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Plain notes without an extension.\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		},
+	})
+}
+
+func TestContentTypeCustomMapping(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/robots.txt",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithMIMETypes(map[string]string{
+				"TXT": "text/x-robots",
+			}),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Disallow: *\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Type": "text/x-robots; charset=utf-8",
+		},
+	})
+}
+
+func panickingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Partial", "true")
+		panic("something went wrong")
+	})
+}
+
+func TestRecoverErrorPage(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "text/html,application/xhtml+xml,*/*;q=0.8",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithErrorPage(http.StatusInternalServerError, "500.html"),
+			poseidon.WithMiddleware(panickingMiddleware),
+		},
+		ExpectedStatusCode: http.StatusInternalServerError,
+		ExpectedBody:       "Something broke.\n",
+		ExpectedHeaders: map[string]string{
+			"Content-Type": "text/html; charset=utf-8",
+			"X-Partial":    "",
+		},
+	})
+}
+
+func TestRecoverJSON(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "application/json",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithErrorPage(http.StatusInternalServerError, "500.html"),
+			poseidon.WithMiddleware(panickingMiddleware),
+		},
+		ExpectedStatusCode: http.StatusInternalServerError,
+		ExpectedBody:       "{\"status\":500,\"error\":\"Internal Server Error\"}",
+		ExpectedHeaders: map[string]string{
+			"Content-Type": "application/json",
+		},
+	})
+}
+
+func TestRecoverPlainText(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithErrorPage(http.StatusInternalServerError, "500.html"),
+			poseidon.WithMiddleware(panickingMiddleware),
+		},
+		ExpectedStatusCode: http.StatusInternalServerError,
+		ExpectedBody:       "500 internal server error\n",
+	})
+}
+
+func TestRecoverSecurityHeaders(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithSecurityHeaders(poseidon.DefaultSecurityHeaders()),
+			poseidon.WithMiddleware(panickingMiddleware),
+		},
+		ExpectedStatusCode: http.StatusInternalServerError,
+		ExpectedBody:       "500 internal server error\n",
+		ExpectedHeaders: map[string]string{
+			"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+			"X-Frame-Options":           "DENY",
+			"X-Content-Type-Options":    "nosniff",
+			"Content-Security-Policy":   "default-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+			"X-Partial":                 "",
+		},
+	})
+}
+
+func TestErrorPageMethodNotAllowed(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodPut, "/",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "text/html",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithErrorPage(http.StatusMethodNotAllowed, "405.html"),
+		},
+		ExpectedStatusCode: http.StatusMethodNotAllowed,
+		ExpectedBody:       "Method not allowed.\n",
+		ExpectedHeaders: map[string]string{
+			"Allow": "GET, HEAD, OPTIONS",
+		},
+	})
+}
+
+func TestErrorPageInvalidStatus(t *testing.T) {
+	if _, err := poseidon.New(os.DirFS("test_data"), poseidon.WithErrorPage(http.StatusOK, "index.html")); err == nil {
+		t.Fatal("Expected an error for a non error status")
+	}
+}
+
+func fallbackChain() []poseidon.ConfigFunc {
+	return []poseidon.ConfigFunc{
+		poseidon.WithFallback(
+			poseidon.FallbackStatus(http.StatusNotFound),
+			poseidon.MatchExtension(".js", ".css"),
+		),
+		poseidon.WithFallback(
+			poseidon.FallbackFile("folder/index.html", http.StatusOK),
+			poseidon.MatchPathPrefix("/folder/"),
+			poseidon.MatchAccept("text/html"),
+		),
+		poseidon.WithFallback(
+			poseidon.FallbackFile("404.html", http.StatusNotFound),
+		),
+	}
+}
+
+func TestFallbackChainPathPrefix(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder/settings",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "text/html,*/*;q=0.8",
+		},
+		ConfigFuncs:        fallbackChain(),
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "This is in a folder.\n",
+	})
+}
+
+func TestFallbackChainAccept(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder/settings",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "*/*",
+		},
+		ConfigFuncs:        fallbackChain(),
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "custom not found\n",
+	})
+}
+
+func TestFallbackChainExtension(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/folder/missing.js",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "text/html",
+		},
+		ConfigFuncs:        fallbackChain(),
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+	})
+}
+
+func TestFallbackChainSkipsMissingFile(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/foobar",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "text/html",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithFallback(poseidon.FallbackFile("missing.html", http.StatusOK)),
+			poseidon.WithFallback(poseidon.FallbackIndex(), poseidon.MatchExtension("")),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Hello there.\n",
+	})
+}
+
+func TestFallbackChainBeforeNotFoundFile(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/foobar",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "text/html",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithFallback(poseidon.FallbackIndex()),
+			poseidon.WithCustomNotFoundFile("404.html"),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Hello there.\n",
+	})
+}
+
+func TestFallbackNearestFile(t *testing.T) {
+	testCases := map[string]string{
+		"/docs/guide/missing": "Docs not found.\n",
+		"/docs/":              "Docs not found.\n",
+		"/blog/missing":       "custom not found\n",
+	}
+
+	for requestPath, expectedBody := range testCases {
+		testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, requestPath,
+				nil,
+			),
+			RequestHeaders: map[string]string{
+				"Accept": "text/html",
+			},
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithCustomIndex("missing.html"),
+				poseidon.WithFallback(poseidon.FallbackNearestFile("404.html", http.StatusNotFound)),
+			},
+			ExpectedStatusCode: http.StatusNotFound,
+			ExpectedBody:       expectedBody,
+		})
+	}
+}
+
+func TestFallbackNearestIndex(t *testing.T) {
+	testCases := map[string]string{
+		"/docs/guide/install": "Docs app.\n",
+		"/docs/guide/":        "Docs app.\n",
+		"/blog/post":          "Hello there.\n",
+	}
+
+	for requestPath, expectedBody := range testCases {
+		testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, requestPath,
+				nil,
+			),
+			RequestHeaders: map[string]string{
+				"Accept": "text/html",
+			},
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithFallback(poseidon.FallbackNearestIndex()),
+			},
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedBody:       expectedBody,
+		})
+	}
+}
+
+func TestFingerprintedAssets(t *testing.T) {
+	testCases := map[string]string{
+		"/assets/index-BxWq3F2a.js":      "public,max-age=31536000,immutable",
+		"/assets/main.3e1f2a7c.css":      "public,max-age=31536000,immutable",
+		"/assets/settings.css?v=3e1f2a7": "public,max-age=31536000,immutable",
+		"/assets/settings.css":           "no-cache, must-revalidate",
+		"/assets/settings.css?v=1":       "no-cache, must-revalidate",
+		"/assets/settings.css?v=x":       "no-cache, must-revalidate",
+		"/about.html?v=3e1f2a7":          "no-cache, must-revalidate",
+		"/app.js":                        "no-cache, must-revalidate",
+	}
+
+	for requestPath, expectedCacheControl := range testCases {
+		testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, requestPath,
+				nil,
+			),
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithCachePolicy(),
+				poseidon.WithFingerprintedAssets(),
+			},
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedHeaders: map[string]string{
+				"Cache-Control": expectedCacheControl,
+			},
+			SkipBodyCheck: true,
+		})
+	}
+}
+
+func TestFingerprintedAssetsCustomPattern(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/assets/settings.css",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithFingerprintedAssets(`^/assets/`),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedHeaders: map[string]string{
+			"Cache-Control": "public,max-age=31536000,immutable",
+		},
+		SkipBodyCheck: true,
+	})
+}
+
+func TestFingerprintedAssetsNotFound(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/assets/missing-BxWq3F2a.js",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithFingerprintedAssets(),
+		},
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedHeaders: map[string]string{
+			"Cache-Control": "no-cache, must-revalidate",
+		},
+		ExpectedBody: "404 page not found\n",
+	})
+}
+
+func cacheRules(t *testing.T) []poseidon.ConfigFunc {
+	htmlPolicy, err := poseidon.ParseCachePolicy("max-age=60, stale-while-revalidate=600, stale-if-error=86400, cdn-max-age=3600, surrogate-max-age=86400")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []poseidon.ConfigFunc{
+		poseidon.WithCachePolicy(),
+		poseidon.WithFingerprintedAssets(),
+		poseidon.WithCacheRule("/assets/main.*", poseidon.CachePolicy{NoStore: true}),
+		poseidon.WithCacheRule(".css", poseidon.CachePolicy{MaxAge: time.Hour, SharedMaxAge: 24 * time.Hour}),
+		poseidon.WithCacheRule("text/html", htmlPolicy),
+		poseidon.WithCacheRule("downloads/", poseidon.CachePolicy{Private: true, MaxAge: time.Minute}),
+	}
+}
+
+func TestCacheRules(t *testing.T) {
+	testCases := map[string]map[string]string{
+		"/assets/settings.css": {
+			"Cache-Control": "public, max-age=3600, s-maxage=86400",
+			"Pragma":        "",
+		},
+		"/assets/main.3e1f2a7c.css": {
+			"Cache-Control": "no-store",
+		},
+		"/assets/index-BxWq3F2a.js": {
+			"Cache-Control": "public,max-age=31536000,immutable",
+		},
+		"/about.html": {
+			"Cache-Control":     "public, max-age=60, stale-while-revalidate=600, stale-if-error=86400",
+			"CDN-Cache-Control": "max-age=3600",
+			"Surrogate-Control": "max-age=86400",
+		},
+		"/downloads/report.txt": {
+			"Cache-Control": "private, max-age=60",
+		},
+		"/app.js": {
+			"Cache-Control": "no-cache, must-revalidate",
+		},
+	}
+
+	for requestPath, expectedHeaders := range testCases {
+		testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, requestPath,
+				nil,
+			),
+			ConfigFuncs:        cacheRules(t),
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedHeaders:    expectedHeaders,
+			SkipBodyCheck:      true,
+		})
+	}
+}
+
+func TestCacheRulesNotFound(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/missing.html",
+			nil,
+		),
+		ConfigFuncs: append(cacheRules(t),
+			poseidon.WithNotFoundCachePolicy(poseidon.CachePolicy{MaxAge: 10 * time.Second}),
+		),
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+		ExpectedHeaders: map[string]string{
+			"Cache-Control":     "public, max-age=10",
+			"CDN-Cache-Control": "",
+		},
+	})
+}
+
+func TestCacheRulesClientSideRouting(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/foobar",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept": "text/html",
+		},
+		ConfigFuncs: append(cacheRules(t),
+			poseidon.WithClientSideRouting(),
+			poseidon.WithFallbackCachePolicy(poseidon.CachePolicy{NoCache: true, StaleIfError: time.Hour}),
+		),
+		ExpectedStatusCode: http.StatusOK,
+		ExpectedBody:       "Hello there.\n",
+		ExpectedHeaders: map[string]string{
+			"Cache-Control": "public, no-cache, max-age=0, stale-if-error=3600",
+		},
+	})
+}
+
+func TestParseCachePolicyInvalid(t *testing.T) {
+	for _, value := range []string{"max-age=soon", "max-age=-1", "forever"} {
+		if _, err := poseidon.ParseCachePolicy(value); err == nil {
+			t.Fatalf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestRedirectsFile(t *testing.T) {
+	testCases := []struct {
+		Path             string
+		ExpectedStatus   int
+		ExpectedLocation string
+		ExpectedBody     string
+		RequestHeaders   map[string]string
+	}{
+		{"/old-about", http.StatusMovedPermanently, "/about.html", "", nil},
+		{"/old-about/?ref=nav", http.StatusMovedPermanently, "/about.html?ref=nav", "", nil},
+		{"/blog/2024/hello", http.StatusFound, "/posts/hello?year=2024", "", nil},
+		{"/blog/2024/hello?year=1999&ref=x", http.StatusFound, "/posts/hello?year=2024", "", nil},
+		{"/store?id=7", http.StatusMovedPermanently, "/products/7?id=7", "", nil},
+		{"/store", http.StatusNotFound, "", "404 page not found\n", nil},
+		{"/news/report.txt", http.StatusOK, "", "report\n", nil},
+		{"/app.js", http.StatusOK, "", "console.log(\"hello\");\n", nil},
+		{"/fr/index.html", http.StatusOK, "", "This is in a folder.\n", map[string]string{"Accept-Language": "fr-CA,en;q=0.5"}},
+		{"/fr/index.html", http.StatusNotFound, "", "404 page not found\n", map[string]string{"Accept-Language": "de"}},
+		{"/robots.txt", http.StatusFound, "/about.html", "", nil},
+		{"/gone", http.StatusNotFound, "", "custom not found\n", nil},
+		{"/_redirects", http.StatusNotFound, "", "404 page not found\n", nil},
+	}
+
+	for _, testCase := range testCases {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, testCase.Path,
+				nil,
+			),
+			RequestHeaders: testCase.RequestHeaders,
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithRedirectsFile(),
+			},
+			ExpectedStatusCode: testCase.ExpectedStatus,
+			ExpectedBody:       testCase.ExpectedBody,
+			SkipBodyCheck:      testCase.ExpectedBody == "",
+		})
+
+		if location := recorder.Header().Get("Location"); location != testCase.ExpectedLocation {
+			t.Fatalf("Unexpected Location for %s, Got: %s, Expected: %s", testCase.Path, location, testCase.ExpectedLocation)
+		}
+	}
+}
+
+func TestRedirectsFileReportsInvalidLines(t *testing.T) {
+	output := &strings.Builder{}
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	if _, err := poseidon.New(os.DirFS("test_data"), poseidon.WithRedirectsFile()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"_redirects: line 17: missing target",
+		"_redirects: line 18: invalid status: 418!x",
+		"_redirects: line 19: unsupported condition: Role",
+		"_redirects: line 20: splats are only allowed at the end: /*/edit",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("Expected %q in the log, Got: %s", expected, output.String())
+		}
+	}
+}
+
+func TestHeadersFile(t *testing.T) {
+	testCases := map[string]map[string]string{
+		"/index.html": {
+			"X-Frame-Options": "SAMEORIGIN",
+			"X-Robots-Tag":    "noindex, nofollow",
+		},
+		"/downloads/report.txt": {
+			"X-Frame-Options": "DENY",
+			"X-Robots-Tag":    "",
+			"Cache-Control":   "public, max-age=600",
+			"Link":            "</downloads/report.txt>; rel=\"canonical\"",
+		},
+		"/folder/index.html": {
+			"Content-Length": "21",
+		},
+	}
+
+	for requestPath, expectedHeaders := range testCases {
+		testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, requestPath,
+				nil,
+			),
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithCachePolicy(),
+				poseidon.WithHeadersFile(),
+			},
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedHeaders:    expectedHeaders,
+			SkipBodyCheck:      true,
+		})
+	}
+}
+
+func TestHeadersFileVary(t *testing.T) {
+	recorder := testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/downloads/report.txt",
+			nil,
+		),
+		RequestHeaders: map[string]string{
+			"Accept-Encoding": "gzip",
+		},
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithHeadersFile(),
+			poseidon.WithCompression(),
+			poseidon.WithCompressionMinSize(0),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		SkipBodyCheck:      true,
+	})
+
+	vary := strings.Join(recorder.Header().Values("Vary"), ", ")
+	if !strings.Contains(vary, "Origin") || !strings.Contains(vary, "Accept-Encoding") {
+		t.Fatalf("Unexpected Vary: %s", vary)
+	}
+}
+
+func TestHeadersFileNotServed(t *testing.T) {
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/_headers",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithHeadersFile(),
+		},
+		ExpectedStatusCode: http.StatusNotFound,
+		ExpectedBody:       "404 page not found\n",
+		ExpectedHeaders: map[string]string{
+			"X-Frame-Options": "DENY",
+		},
+	})
+}
+
+func TestHeadersFileReload(t *testing.T) {
+	fileSystem := fstest.MapFS{
+		"index.html": {Data: []byte("Hello there.\n")},
+		"_headers":   {Data: []byte("/*\n  X-Version: 1\n"), ModTime: time.Unix(1, 0)},
+	}
+
+	service, err := poseidon.New(fileSystem, poseidon.WithHeadersFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []string{"1", "2"} {
+		if version == "2" {
+			fileSystem["_headers"] = &fstest.MapFile{Data: []byte("/*\n  X-Version: 2\n"), ModTime: time.Unix(2, 0)}
+		}
+
+		recorder := httptest.NewRecorder()
+		service.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		if recorder.Header().Get("X-Version") != version {
+			t.Fatalf("Unexpected X-Version, Got: %s, Expected: %s", recorder.Header().Get("X-Version"), version)
+		}
+	}
+}
+
+func TestHeadersFileReportsInvalidLines(t *testing.T) {
+	output := &strings.Builder{}
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithHeadersFile(),
+		},
+		ExpectedStatusCode: http.StatusOK,
+		SkipBodyCheck:      true,
+	})
+
+	for _, expected := range []string{
+		"_headers: line 1: header without a valid path",
+		"_headers: line 21: header can't be set: Content-Length",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("Expected %q in the log, Got: %s", expected, output.String())
+		}
+	}
+}
+
+func TestRedirectAndRewriteRules(t *testing.T) {
+	configFuncs := []poseidon.ConfigFunc{
+		poseidon.WithRedirect("/old/{path...}", "/new/{path}", http.StatusMovedPermanently),
+		poseidon.WithRedirect("GET /posts/{id}/{$}", "/articles/{id}?from=posts", http.StatusFound),
+		poseidon.WithRedirect("docs.example.com/", "https://example.com/docs/", http.StatusPermanentRedirect),
+		poseidon.WithRedirect(`^/(\d{4})/(?P<slug>[a-z-]+)\.html$`, "/blog/$1/${slug}", http.StatusMovedPermanently),
+		poseidon.WithRedirect(`guide.example.com^/guide/(.*)$`, "https://example.com/docs/$1", http.StatusMovedPermanently),
+		poseidon.WithRedirect(`^/go/(.*)$`, "/$1", http.StatusFound),
+		poseidon.WithRewrite("/reports/{name}", "/downloads/{name}.txt"),
+		poseidon.WithRewrite("/{path...}", "/index.html"),
+	}
+
+	testCases := []struct {
+		Host             string
+		Path             string
+		ExpectedStatus   int
+		ExpectedLocation string
+		ExpectedBody     string
+	}{
+		{"", "/old/a/b?page=2", http.StatusMovedPermanently, "/new/a/b?page=2", ""},
+		{"", "/posts/42/?from=feed&ref=x", http.StatusFound, "/articles/42?from=posts&ref=x", ""},
+		{"", "/posts/42/comments", http.StatusOK, "", "Hello there.\n"},
+		{"docs.example.com:8080", "/intro", http.StatusPermanentRedirect, "https://example.com/docs/", ""},
+		{"", "/2024/hello-world.html", http.StatusMovedPermanently, "/blog/2024/hello-world", ""},
+		{"guide.example.com", "/guide/setup", http.StatusMovedPermanently, "https://example.com/docs/setup", ""},
+		{"", "/guide/setup", http.StatusOK, "", "Hello there.\n"},
+		{"", "/go/about", http.StatusFound, "/about", ""},
+		{"", "/go//evil.example", http.StatusOK, "", "Hello there.\n"},
+		{"", "/go/%5Cevil.example", http.StatusOK, "", "Hello there.\n"},
+		{"", "/reports/report", http.StatusOK, "", "report\n"},
+		{"", "/about.html", http.StatusOK, "", "About us.\n"},
+		{"", "/anything/else", http.StatusOK, "", "Hello there.\n"},
+	}
+
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, testCase.Path, nil)
+		if testCase.Host != "" {
+			request.Host = testCase.Host
+		}
+
+		recorder := testService(t, TestCase{
+			Request:            request,
+			ConfigFuncs:        configFuncs,
+			ExpectedStatusCode: testCase.ExpectedStatus,
+			ExpectedBody:       testCase.ExpectedBody,
+			SkipBodyCheck:      testCase.ExpectedBody == "",
+		})
+
+		if location := recorder.Header().Get("Location"); location != testCase.ExpectedLocation {
+			t.Fatalf("Unexpected Location for %s, Got: %s, Expected: %s", testCase.Path, location, testCase.ExpectedLocation)
+		}
+	}
+}
+
+func TestRedirectRuleInvalid(t *testing.T) {
+	for _, configFunc := range []poseidon.ConfigFunc{
+		poseidon.WithRedirect("/old", "/new", http.StatusOK),
+		poseidon.WithRedirect("/old/{path...}/edit", "/new", http.StatusFound),
+		poseidon.WithRedirect("/old/x{id}", "/new", http.StatusFound),
+		poseidon.WithRedirect("^/(", "/new", http.StatusFound),
+		poseidon.WithRewrite("/old", "https://example.com/"),
+	} {
+		if _, err := poseidon.New(os.DirFS("test_data"), configFunc); err == nil {
+			t.Fatal("Expected an error for an invalid rule")
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprintf(w, "%s %s host=%s for=%s proto=%s prefix=%s",
+			r.Method, r.URL.RequestURI(), r.Host,
+			r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Forwarded-Proto"), r.Header.Get("X-Forwarded-Prefix"),
+		)
+	}))
+	defer upstream.Close()
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	testCases := []struct {
+		Method       string
+		Path         string
+		ConfigFunc   poseidon.ConfigFunc
+		ExpectedBody string
+	}{
+		{
+			http.MethodGet, "/api/users?page=2",
+			poseidon.WithProxy("/api/", upstream.URL),
+			"GET /api/users?page=2 host=" + upstreamHost + " for=192.0.2.1 proto=http prefix=",
+		},
+		{
+			http.MethodPost, "/api/users",
+			poseidon.WithProxy("/api/", upstream.URL+"/"),
+			"POST /users host=" + upstreamHost + " for=192.0.2.1 proto=http prefix=/api",
+		},
+		{
+			http.MethodGet, "/api/users",
+			poseidon.WithProxy("/api/", upstream.URL+"/v2/"),
+			"GET /v2/users host=" + upstreamHost + " for=192.0.2.1 proto=http prefix=/api",
+		},
+		{
+			http.MethodGet, "/index.html",
+			poseidon.WithProxy("/", upstream.URL, poseidon.ProxyPreserveHost()),
+			"GET /index.html host=example.com for=192.0.2.1 proto=http prefix=",
+		},
+	}
+
+	for _, testCase := range testCases {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				testCase.Method, testCase.Path,
+				nil,
+			),
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithCachePolicy(),
+				testCase.ConfigFunc,
+			},
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedBody:       testCase.ExpectedBody,
+		})
+
+		if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != "max-age=60" {
+			t.Fatalf("Unexpected Cache-Control, Got: %s, Expected: max-age=60", cacheControl)
+		}
+	}
+}
+
+func TestProxyPrefixSegments(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.URL.RequestURI())
+	}))
+	defer upstream.Close()
+
+	configFuncs := []poseidon.ConfigFunc{
+		poseidon.WithProxy("/api", upstream.URL+"/base"),
+	}
+
+	for path, expectedBody := range map[string]string{
+		"/api":       "/base",
+		"/api/users": "/base/users",
+	} {
+		testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, path,
+				nil,
+			),
+			ConfigFuncs:        configFuncs,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedBody:       expectedBody,
+		})
+	}
+
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/apiary",
+			nil,
+		),
+		ConfigFuncs:        configFuncs,
+		ExpectedStatusCode: http.StatusNotFound,
+		SkipBodyCheck:      true,
+	})
+}
+
+func TestProxyErrors(t *testing.T) {
+	output := &strings.Builder{}
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/api/users",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithProxy("/api/", closed.URL),
+		},
+		ExpectedStatusCode: http.StatusBadGateway,
+		ExpectedBody:       "502 bad gateway\n",
+	})
+
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/api/users",
+			nil,
+		),
+		ConfigFuncs: []poseidon.ConfigFunc{
+			poseidon.WithProxy("/api/", slow.URL, poseidon.ProxyTimeout(50*time.Millisecond)),
+		},
+		ExpectedStatusCode: http.StatusGatewayTimeout,
+		ExpectedBody:       "504 gateway timeout\n",
+	})
+
+	if _, err := poseidon.New(os.DirFS("test_data"), poseidon.WithProxy("/api/", "127.0.0.1:8080")); err == nil {
+		t.Fatal("Expected an error for an upstream without a scheme")
+	}
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			t.Errorf("Unexpected Upgrade header: %s", r.Header.Get("Upgrade"))
+		}
+
+		conn, buffer, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		_, _ = buffer.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = buffer.Flush()
+
+		// Echo a single message
+		message, _ := buffer.ReadString('\n')
+		_, _ = buffer.WriteString(message)
+		_ = buffer.Flush()
+	}))
+	defer upstream.Close()
+
+	service, err := poseidon.New(os.DirFS("test_data"), poseidon.WithProxy("/ws", upstream.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nAccept-Encoding: gzip\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Unexpected Status Code, Got: %d, Expected: %d", response.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	_, _ = io.WriteString(conn, "hello\n")
+	echo, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if echo != "hello\n" {
+		t.Fatalf("Unexpected echo, Got: %q, Expected: %q", echo, "hello\n")
+	}
+}
+
+func TestProxyCache(t *testing.T) {
+	requests := map[string]int{}
+	mutex := sync.Mutex{}
+	failing := false
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.URL.Path]++
+		count := requests[r.URL.Path]
+		mutex.Unlock()
+
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			_, _ = io.WriteString(w, r.Header.Get("Accept-Language")+" ")
+		case "/stale":
+			w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+			w.Header().Set("Age", "10")
+		case "/error":
+			if failing {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+			w.Header().Set("Age", "10")
+		}
+
+		_, _ = fmt.Fprintf(w, "response %d", count)
+	}))
+	defer upstream.Close()
+
+	cache := poseidon.NewProxyCache(1 << 20)
+	configFuncs := []poseidon.ConfigFunc{
+		poseidon.WithProxy("/api/", upstream.URL+"/", poseidon.ProxyCaching(cache)),
+	}
+
+	testCases := []struct {
+		Path          string
+		Language      string
+		ExpectedCache string
+		ExpectedBody  string
+	}{
+		{"/api/fresh", "", "MISS", "response 1"},
+		{"/api/fresh", "", "HIT", "response 1"},
+		{"/api/private", "", "MISS", "response 1"},
+		{"/api/private", "", "MISS", "response 2"},
+		{"/api/etag", "", "MISS", "response 1"},
+		{"/api/etag", "", "REVALIDATED", "response 1"},
+		{"/api/vary", "en", "MISS", "en response 1"},
+		{"/api/vary", "fr", "MISS", "fr response 2"},
+		{"/api/vary", "en", "HIT", "en response 1"},
+		{"/api/stale", "", "MISS", "response 1"},
+		{"/api/stale", "", "STALE", "response 1"},
+		{"/api/error", "", "MISS", "response 1"},
+		{"/api/error", "", "STALE", "response 1"},
+	}
+
+	for _, testCase := range testCases {
+		failing = testCase.ExpectedCache == "STALE" && testCase.Path == "/api/error"
+
+		request := httptest.NewRequest(http.MethodGet, testCase.Path, nil)
+		if testCase.Language != "" {
+			request.Header.Set("Accept-Language", testCase.Language)
+		}
+
+		recorder := testService(t, TestCase{
+			Request:            request,
+			ConfigFuncs:        configFuncs,
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedBody:       testCase.ExpectedBody,
+		})
+
+		if xCache := recorder.Header().Get("X-Cache"); xCache != testCase.ExpectedCache {
+			t.Fatalf("Unexpected X-Cache for %s, Got: %s, Expected: %s", testCase.Path, xCache, testCase.ExpectedCache)
+		}
+		if cacheStatus := recorder.Header().Get("Cache-Status"); !strings.HasPrefix(cacheStatus, "poseidon; ") {
+			t.Fatalf("Unexpected Cache-Status for %s: %s", testCase.Path, cacheStatus)
+		}
+	}
+
+	// The stale response was refreshed in the background
+	deadline := time.Now().Add(time.Second)
+	for {
+		mutex.Lock()
+		count := requests["/stale"]
+		mutex.Unlock()
+
+		if count == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected upstream requests for /stale, Got: %d, Expected: 2", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Client validators are checked against the stored response
+	testService(t, TestCase{
+		Request: httptest.NewRequest(
+			http.MethodGet, "/api/etag",
+			nil,
+		),
+		RequestHeaders:     map[string]string{"If-None-Match": `"v1"`},
+		ConfigFuncs:        configFuncs,
+		ExpectedStatusCode: http.StatusNotModified,
+		ExpectedBody:       "",
+	})
+}
+
+func TestProxyCacheCoalescing(t *testing.T) {
+	requests := atomic.Int32{}
+	release := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "shared")
+	}))
+	defer upstream.Close()
+
+	service, err := poseidon.New(os.DirFS("test_data"), poseidon.WithProxy("/api/", upstream.URL, poseidon.ProxyCaching(poseidon.NewProxyCache(1<<20))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorders := make([]*httptest.ResponseRecorder, 10)
+	group := sync.WaitGroup{}
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			service.ServeHTTP(recorders[i], httptest.NewRequest(http.MethodGet, "/api/data", nil))
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	group.Wait()
+
+	if count := requests.Load(); count != 1 {
+		t.Fatalf("Unexpected upstream requests, Got: %d, Expected: 1", count)
+	}
+
+	for _, recorder := range recorders {
+		if recorder.Body.String() != "shared" {
+			t.Fatalf("Unexpected body, Got: %q, Expected: %q", recorder.Body.String(), "shared")
+		}
+	}
+}
+
+// stalledResponseWriter is a client that doesn't read the body.
+type stalledResponseWriter struct {
+	header  http.Header
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *stalledResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *stalledResponseWriter) WriteHeader(statusCode int) {}
+
+func (w *stalledResponseWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.writing)
+	})
+	<-w.release
+
+	return len(p), nil
+}
+
+func TestProxyCacheCoalescingSlowClient(t *testing.T) {
+	requests := atomic.Int32{}
+	rest := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		// The first part reaches the client before the end of the body
+		_, _ = io.WriteString(w, "sha")
+		w.(http.Flusher).Flush()
+		<-rest
+		_, _ = io.WriteString(w, "red")
+	}))
+	defer upstream.Close()
+
+	service, err := poseidon.New(os.DirFS("test_data"), poseidon.WithProxy("/api/", upstream.URL, poseidon.ProxyCaching(poseidon.NewProxyCache(1<<20))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader := &stalledResponseWriter{header: http.Header{}, writing: make(chan struct{}), release: make(chan struct{})}
+	defer close(leader.release)
+	go service.ServeHTTP(leader, httptest.NewRequest(http.MethodGet, "/api/data", nil))
+	<-leader.writing
+
+	follower := httptest.NewRecorder()
+	followed := make(chan struct{})
+	go func() {
+		defer close(followed)
+		service.ServeHTTP(follower, httptest.NewRequest(http.MethodGet, "/api/data", nil))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(rest)
+
+	select {
+	case <-followed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiting request not to depend on the first client")
+	}
+
+	if count := requests.Load(); count != 1 {
+		t.Fatalf("Unexpected upstream requests, Got: %d, Expected: 1", count)
+	}
+
+	if follower.Body.String() != "shared" {
+		t.Fatalf("Unexpected body, Got: %q, Expected: %q", follower.Body.String(), "shared")
+	}
+}
+
+func TestProxyCacheEviction(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer upstream.Close()
+
+	configFuncs := []poseidon.ConfigFunc{
+		poseidon.WithProxy("/api/", upstream.URL, poseidon.ProxyCaching(poseidon.NewProxyCache(2500, poseidon.ProxyCacheMaxObjectSize(1500)))),
+	}
+
+	for _, step := range []struct {
+		Path          string
+		ExpectedCache string
+	}{
+		{"/api/a", "MISS"},
+		{"/api/b", "MISS"},
+		{"/api/a", "HIT"},
+		// Evicts b, the least recently used
+		{"/api/c", "MISS"},
+		{"/api/a", "HIT"},
+		{"/api/b", "MISS"},
+	} {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, step.Path,
+				nil,
+			),
+			ConfigFuncs:        configFuncs,
+			ExpectedStatusCode: http.StatusOK,
+			SkipBodyCheck:      true,
+		})
+
+		if xCache := recorder.Header().Get("X-Cache"); xCache != step.ExpectedCache {
+			t.Fatalf("Unexpected X-Cache for %s, Got: %s, Expected: %s", step.Path, xCache, step.ExpectedCache)
+		}
+	}
+}
+
+func TestProxyCacheMaxObjectSize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		switch r.URL.Path {
+		case "/large":
+			_, _ = io.WriteString(w, strings.Repeat("x", 1000))
+		case "/chunked":
+			// Flushing before the end leaves the length unknown
+			_, _ = io.WriteString(w, strings.Repeat("x", 1000))
+			w.(http.Flusher).Flush()
+		}
+		_, _ = io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer upstream.Close()
+
+	configFuncs := []poseidon.ConfigFunc{
+		poseidon.WithProxy("/api/", upstream.URL+"/", poseidon.ProxyCaching(poseidon.NewProxyCache(1<<20, poseidon.ProxyCacheMaxObjectSize(1500)))),
+	}
+
+	for _, step := range []struct {
+		Path          string
+		ExpectedCache string
+		ExpectedSize  int
+	}{
+		{"/api/small", "MISS", 1000},
+		{"/api/small", "HIT", 1000},
+		{"/api/large", "MISS", 2000},
+		{"/api/large", "MISS", 2000},
+		{"/api/chunked", "MISS", 2000},
+		{"/api/chunked", "MISS", 2000},
+	} {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, step.Path,
+				nil,
+			),
+			ConfigFuncs:        configFuncs,
+			ExpectedStatusCode: http.StatusOK,
+			SkipBodyCheck:      true,
+		})
+
+		if xCache := recorder.Header().Get("X-Cache"); xCache != step.ExpectedCache {
+			t.Fatalf("Unexpected X-Cache for %s, Got: %s, Expected: %s", step.Path, xCache, step.ExpectedCache)
+		}
+		if recorder.Body.Len() != step.ExpectedSize {
+			t.Fatalf("Unexpected body size for %s, Got: %d, Expected: %d", step.Path, recorder.Body.Len(), step.ExpectedSize)
+		}
+	}
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestProxyCacheCoalescingPanic(t *testing.T) {
+	requests := atomic.Int32{}
+	release := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "shared")
+	}))
+	defer upstream.Close()
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if requests.Add(1) == 1 {
+			<-release
+			panic(http.ErrAbortHandler)
+		}
+
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	service, err := poseidon.New(os.DirFS("test_data"), poseidon.WithProxy("/api/", upstream.URL,
+		poseidon.ProxyTransport(transport),
+		poseidon.ProxyCaching(poseidon.NewProxyCache(1<<20)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(recorder *httptest.ResponseRecorder) {
+		defer func() {
+			_ = recover()
+		}()
+
+		service.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/data", nil))
+	}
+
+	group := sync.WaitGroup{}
+	group.Add(1)
+	go func() {
+		defer group.Done()
+		serve(httptest.NewRecorder())
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	follower := httptest.NewRecorder()
+	followed := make(chan struct{})
+	go func() {
+		defer close(followed)
+		serve(follower)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	group.Wait()
+
+	select {
+	case <-followed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiting request to be released after the panic")
+	}
+
+	if follower.Body.String() != "shared" {
+		t.Fatalf("Unexpected body, Got: %q, Expected: %q", follower.Body.String(), "shared")
+	}
+}
+
+func TestProxyCacheDisk(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "from disk")
+	}))
+	defer upstream.Close()
+
+	directory := t.TempDir()
+	for _, expectedCache := range []string{"MISS", "HIT"} {
+		// A new cache on the same directory, like after a restart
+		cache, err := poseidon.NewDiskProxyCache(directory, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, "/api/data",
+				nil,
+			),
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithProxy("/api/", upstream.URL, poseidon.ProxyCaching(cache)),
+			},
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedBody:       "from disk",
+		})
+
+		if xCache := recorder.Header().Get("X-Cache"); xCache != expectedCache {
+			t.Fatalf("Unexpected X-Cache, Got: %s, Expected: %s", xCache, expectedCache)
+		}
+	}
+}
+
+func TestHostRouter(t *testing.T) {
+	newService := func(content string) *poseidon.Service {
+		service, err := poseidon.New(fstest.MapFS{
+			"index.html": {Data: []byte(content)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return service
+	}
+
+	router := poseidon.NewHostRouter()
+	router.Handle("example.com", newService("example"))
+	router.Handle("preview.example.com", newService("preview"))
+	if err := router.HandleWildcard("*.preview.example.com", func(label string) (http.Handler, error) {
+		if label == "missing" {
+			return nil, fs.ErrNotExist
+		}
+
+		return newService("preview " + label), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Host           string
+		ExpectedStatus int
+		ExpectedBody   string
+	}{
+		{"example.com", http.StatusOK, "example"},
+		{"EXAMPLE.com:8080", http.StatusOK, "example"},
+		{"preview.example.com", http.StatusOK, "preview"},
+		{"pr-42.preview.example.com", http.StatusOK, "preview pr-42"},
+		{"a.b.preview.example.com", http.StatusNotFound, "404 page not found\n"},
+		{"missing.preview.example.com", http.StatusNotFound, "404 page not found\n"},
+		{"other.com", http.StatusNotFound, "404 page not found\n"},
+	}
+
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Host = testCase.Host
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != testCase.ExpectedStatus {
+			t.Fatalf("Unexpected Status Code for %s, Got: %d, Expected: %d", testCase.Host, recorder.Code, testCase.ExpectedStatus)
+		}
+		if recorder.Body.String() != testCase.ExpectedBody {
+			t.Fatalf("Unexpected body for %s, Got: %q, Expected: %q", testCase.Host, recorder.Body.String(), testCase.ExpectedBody)
+		}
+	}
+
+	router.HandleDefault(newService("default"))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Host = "other.com"
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "default" {
+		t.Fatalf("Unexpected body, Got: %q, Expected: %q", recorder.Body.String(), "default")
+	}
+
+	if err := router.HandleWildcard("preview.*.com", nil); err == nil {
+		t.Fatal("Expected an error for an invalid wildcard")
+	}
+}
+
+func TestHostRouterWildcardBuilds(t *testing.T) {
+	builds := map[string]int{}
+	mutex := sync.Mutex{}
+	release := make(chan struct{})
+
+	router := poseidon.NewHostRouter()
+	if err := router.HandleWildcard("*.preview.example.com", func(label string) (http.Handler, error) {
+		mutex.Lock()
+		builds[label]++
+		mutex.Unlock()
+
+		switch label {
+		case "slow":
+			<-release
+		case "missing":
+			return nil, fs.ErrNotExist
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, label)
+		}), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(host string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Host = host
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	slow := make(chan *httptest.ResponseRecorder, 2)
+	for range 2 {
+		go func() {
+			slow <- serve("slow.preview.example.com")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// A slow build doesn't hold up other labels
+	if body := serve("fast.preview.example.com").Body.String(); body != "fast" {
+		t.Fatalf("Unexpected body, Got: %q, Expected: %q", body, "fast")
+	}
+
+	close(release)
+	for range 2 {
+		if body := (<-slow).Body.String(); body != "slow" {
+			t.Fatalf("Unexpected body, Got: %q, Expected: %q", body, "slow")
+		}
+	}
+
+	// Failed builds are remembered for a while
+	for range 3 {
+		if code := serve("missing.preview.example.com").Code; code != http.StatusNotFound {
+			t.Fatalf("Unexpected Status Code, Got: %d, Expected: %d", code, http.StatusNotFound)
+		}
+	}
+
+	for label, expected := range map[string]int{"slow": 1, "fast": 1, "missing": 1} {
+		if builds[label] != expected {
+			t.Fatalf("Unexpected builds for %s, Got: %d, Expected: %d", label, builds[label], expected)
+		}
+	}
+}
+
+type testSSRProvider struct{}
+
+func (provider testSSRProvider) HandleRequest(r *http.Request) poseidon.SSRPayload {
+	return poseidon.SSRPayload{
+		Title:  "App",
+		Assets: poseidon.SSRAssets{JS: []string{"assets/app.js"}},
+	}
+}
+
+func TestMount(t *testing.T) {
+	site, err := poseidon.New(os.DirFS("test_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := poseidon.New(os.DirFS("test_data"),
+		poseidon.WithRedirect("/old", "/about.html", http.StatusMovedPermanently),
+		poseidon.WithDirectoryListing(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := poseidon.New(fstest.MapFS{
+		"assets/app.js": {Data: []byte("app();\n")},
+	}, poseidon.WithClientSideRoutingAndServerSideRendering(testSSRProvider{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", site)
+	mux.Handle("/docs/", poseidon.Mount("/docs", docs))
+	mux.Handle("/app/", poseidon.Mount("/app/", app))
+
+	testCases := []struct {
+		Path             string
+		ExpectedStatus   int
+		ExpectedLocation string
+		ExpectedBody     string
+	}{
+		{"/about.html", http.StatusOK, "", "About us."},
+		{"/docs/about.html", http.StatusOK, "", "About us."},
+		{"/docs/folder?page=2", http.StatusTemporaryRedirect, "/docs/folder/?page=2", ""},
+		{"/docs/old", http.StatusMovedPermanently, "/docs/about.html", ""},
+		{"/docs/downloads/", http.StatusOK, "", "Index of /docs/downloads/"},
+		{"/app/assets/app.js", http.StatusOK, "", "app();"},
+		{"/app/settings/profile", http.StatusOK, "", `src="/app/assets/app.js"`},
+	}
+
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, testCase.Path, nil)
+		request.Header.Set("Accept", "text/html")
+
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+
+		if recorder.Code != testCase.ExpectedStatus {
+			t.Fatalf("Unexpected Status Code for %s, Got: %d, Expected: %d", testCase.Path, recorder.Code, testCase.ExpectedStatus)
+		}
+		if location := recorder.Header().Get("Location"); location != testCase.ExpectedLocation {
+			t.Fatalf("Unexpected Location for %s, Got: %s, Expected: %s", testCase.Path, location, testCase.ExpectedLocation)
+		}
+		if !strings.Contains(recorder.Body.String(), testCase.ExpectedBody) {
+			t.Fatalf("Expected %q in the body for %s, Got: %s", testCase.ExpectedBody, testCase.Path, recorder.Body.String())
+		}
+	}
+}
+
+func TestMountNested(t *testing.T) {
+	service, err := poseidon.New(os.DirFS("test_data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := poseidon.Mount("/sites", poseidon.Mount("/main", service))
+
+	testCases := []struct {
+		Path             string
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"/sites/main/about.html", http.StatusOK, ""},
+		{"/sites/main/folder", http.StatusTemporaryRedirect, "/sites/main/folder/"},
+		{"/sites/main", http.StatusMovedPermanently, "/sites/main/"},
+		{"/sites/mainly/about.html", http.StatusNotFound, ""},
+	}
+
+	for _, testCase := range testCases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, testCase.Path, nil))
+
+		if recorder.Code != testCase.ExpectedStatus {
+			t.Fatalf("Unexpected Status Code for %s, Got: %d, Expected: %d", testCase.Path, recorder.Code, testCase.ExpectedStatus)
+		}
+		if location := recorder.Header().Get("Location"); location != testCase.ExpectedLocation {
+			t.Fatalf("Unexpected Location for %s, Got: %s, Expected: %s", testCase.Path, location, testCase.ExpectedLocation)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	for _, path := range []string{"/index.html", "/missing"} {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, path,
+				nil,
+			),
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithSecurityHeaders(poseidon.DefaultSecurityHeaders()),
+			},
+			ExpectedStatusCode: map[string]int{"/index.html": http.StatusOK, "/missing": http.StatusNotFound}[path],
+			SkipBodyCheck:      true,
+		})
+
+		for key, expected := range map[string]string{
+			"Strict-Transport-Security":    "max-age=63072000; includeSubDomains",
+			"X-Frame-Options":              "DENY",
+			"X-Content-Type-Options":       "nosniff",
+			"Referrer-Policy":              "strict-origin-when-cross-origin",
+			"Permissions-Policy":           "camera=(), microphone=(), geolocation=()",
+			"Cross-Origin-Opener-Policy":   "same-origin",
+			"Cross-Origin-Resource-Policy": "same-origin",
+			"Content-Security-Policy":      "default-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+		} {
+			if value := recorder.Header().Get(key); value != expected {
+				t.Fatalf("Unexpected %s for %s, Got: %s, Expected: %s", key, path, value, expected)
+			}
+		}
+	}
+}
+
+type testNonceProvider struct{}
+
+func (provider testNonceProvider) HandleRequest(r *http.Request) poseidon.SSRPayload {
+	return poseidon.SSRPayload{
+		Title:         "App",
+		Assets:        poseidon.SSRAssets{JS: []string{"app.js"}, CSS: []string{"app.css"}},
+		HeaderContent: template.HTML(`<script>window.config = {};</script><SCRIPT NONCE="fixed" src="/vendor.js"></SCRIPT>`),
+	}
+}
+
+func TestSecurityHeadersNonce(t *testing.T) {
+	headers := poseidon.DefaultSecurityHeaders()
+	headers.ReportOnly = true
+
+	nonces := map[string]bool{}
+	for range 2 {
+		recorder := testService(t, TestCase{
+			Request: httptest.NewRequest(
+				http.MethodGet, "/settings",
+				nil,
+			),
+			RequestHeaders: map[string]string{"Accept": "text/html"},
+			ConfigFuncs: []poseidon.ConfigFunc{
+				poseidon.WithSecurityHeaders(headers),
+				poseidon.WithClientSideRoutingAndServerSideRendering(testNonceProvider{}),
+			},
+			ExpectedStatusCode: http.StatusOK,
+			SkipBodyCheck:      true,
+		})
+
+		if recorder.Header().Get("Content-Security-Policy") != "" {
+			t.Fatal("Unexpected enforced Content-Security-Policy in report only mode")
+		}
+
+		policy := recorder.Header().Get("Content-Security-Policy-Report-Only")
+		// The onload handler of the stylesheet preloads is allowed by its hash
+		match := regexp.MustCompile(`script-src 'self' 'nonce-([A-Za-z0-9_-]+)' 'unsafe-hashes' 'sha256-1jAmyYXcRq6zFldLe/GCgIDJBiOONdXjTLgEFMDnDSM='; style-src 'self' 'nonce-([A-Za-z0-9_-]+)'`).FindStringSubmatch(policy)
+		if match == nil || match[1] != match[2] {
+			t.Fatalf("Unexpected Content-Security-Policy-Report-Only: %s", policy)
+		}
+		nonce := match[1]
+		nonces[nonce] = true
+
+		body := recorder.Body.String()
+		for _, expected := range []string{
+			`<script nonce="` + nonce + `">window.config = {};</script>`,
+			`<SCRIPT NONCE="fixed" src="/vendor.js"></SCRIPT>`,
+			`<script defer async src="/app.js" nonce="` + nonce + `"></script>`,
+			`<link rel="preload" href="/app.css" as="style" onload="this.onload=null;this.rel='stylesheet'" nonce="` + nonce + `" />`,
+			`<link rel="stylesheet" href="/app.css" nonce="` + nonce + `">`,
+		} {
+			if !strings.Contains(body, expected) {
+				t.Fatalf("Expected %q in the body, Got: %s", expected, body)
+			}
+		}
+	}
+
+	if len(nonces) != 2 {
+		t.Fatal("Expected a fresh nonce per request")
+	}
+}
+
+func TestParseContentSecurityPolicy(t *testing.T) {
+	value := "default-src 'self'; script-src 'self' https://cdn.example.com; upgrade-insecure-requests; report-uri /csp-reports"
+
+	policy, err := poseidon.ParseContentSecurityPolicy(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if policy.String() != value {
+		t.Fatalf("Unexpected policy, Got: %s, Expected: %s", policy.String(), value)
+	}
+
+	if _, err := poseidon.ParseContentSecurityPolicy("default-src 'self'; scripts-src 'self'"); err == nil {
+		t.Fatal("Expected an error for an unknown directive")
+	}
+}
+
+func testService(t *testing.T, testCase TestCase) *httptest.ResponseRecorder {
 	service, err := poseidon.New(os.DirFS("test_data"), testCase.ConfigFuncs...)
 	if err != nil {
 		t.Fatal(err)
@@ -286,6 +3180,10 @@ func testService(t *testing.T, testCase TestCase) {
 		t.Fatalf("Unexpected Status Code, Got: %d, Expected: %d", recorder.Code, testCase.ExpectedStatusCode)
 	}
 
+	if testCase.SkipBodyCheck {
+		return recorder
+	}
+
 	reader := recorder.Result().Body
 
 	switch recorder.Header().Get("Content-Encoding") {
@@ -294,6 +3192,14 @@ func testService(t *testing.T, testCase TestCase) {
 		if err != nil {
 			t.Fatal(err)
 		}
+	case "br":
+		reader = io.NopCloser(brotli.NewReader(recorder.Body))
+	case "zstd":
+		decoder, err := zstd.NewReader(recorder.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = decoder.IOReadCloser()
 	}
 
 	body, err := io.ReadAll(reader)
@@ -310,4 +3216,6 @@ func testService(t *testing.T, testCase TestCase) {
 			t.Fatalf("Unexpected Header %s, Got: %s, Expected: %s", key, recorder.Header().Get(key), value)
 		}
 	}
+
+	return recorder
 }
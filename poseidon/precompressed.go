@@ -0,0 +1,92 @@
+package poseidon
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+var precompressedExtensions = map[string]string{
+	"br":   ".br",
+	"zstd": ".zst",
+	"gzip": ".gz",
+}
+
+func WithPrecompressedFiles(encodings ...string) ConfigFunc {
+	return func(service *Service) error {
+		if len(encodings) == 0 {
+			encodings = []string{"br", "zstd", "gzip"}
+		}
+
+		for _, encoding := range encodings {
+			if _, ok := precompressedExtensions[encoding]; !ok {
+				return fmt.Errorf("unsupported precompressed encoding: %s", encoding)
+			}
+		}
+
+		service.precompressed = encodings
+
+		return nil
+	}
+}
+
+func WithDirectPrecompressedFileAccess() ConfigFunc {
+	return func(service *Service) error {
+		service.precompressedDirectAccess = true
+
+		return nil
+	}
+}
+
+// isPrecompressedSibling reports whether the path is a precompressed copy of
+// another file that should only be reachable through negotiation.
+func (service *Service) isPrecompressedSibling(path string) bool {
+	if service.precompressedDirectAccess {
+		return false
+	}
+
+	for _, encoding := range service.precompressed {
+		original, found := strings.CutSuffix(path, precompressedExtensions[encoding])
+		if !found || original == "" {
+			continue
+		}
+
+		if stat, err := fs.Stat(service.fileSystem, original); err == nil && !stat.IsDir() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// openPrecompressed opens the best precompressed sibling of the path that
+// the client accepts. The returned file is nil when none is suitable.
+func (service *Service) openPrecompressed(w http.ResponseWriter, r *http.Request, path string) (fs.File, string) {
+	available := []string{}
+	for _, encoding := range service.precompressed {
+		stat, err := fs.Stat(service.fileSystem, path+precompressedExtensions[encoding])
+		if err == nil && stat.Mode().IsRegular() {
+			available = append(available, encoding)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, ""
+	}
+
+	// The response depends on Accept-Encoding from here on
+	addVary(w.Header(), "Accept-Encoding")
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), available)
+	if encoding == "" {
+		return nil, ""
+	}
+
+	file, err := service.fileSystem.Open(path + precompressedExtensions[encoding])
+	if err != nil {
+		return nil, ""
+	}
+
+	return file, encoding
+}
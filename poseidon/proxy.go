@@ -0,0 +1,151 @@
+package poseidon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProxyTimeout     = 30 * time.Second
+	defaultProxyDialTimeout = 10 * time.Second
+)
+
+type proxyRoute struct {
+	prefix       string
+	upstream     *url.URL
+	preserveHost bool
+	timeout      time.Duration
+	transport    http.RoundTripper
+	cache        *ProxyCache
+}
+
+// ProxyOption configures a route added by WithProxy.
+type ProxyOption func(route *proxyRoute)
+
+// ProxyPreserveHost forwards the Host header of the client instead of the
+// host of the upstream.
+func ProxyPreserveHost() ProxyOption {
+	return func(route *proxyRoute) {
+		route.preserveHost = true
+	}
+}
+
+// ProxyTimeout limits how long the upstream can take to send the headers of
+// its response (30 seconds by default).
+func ProxyTimeout(timeout time.Duration) ProxyOption {
+	return func(route *proxyRoute) {
+		route.timeout = timeout
+	}
+}
+
+// ProxyTransport sends the upstream requests through the round tripper
+// instead of a transport using the proxy timeout.
+func ProxyTransport(transport http.RoundTripper) ProxyOption {
+	return func(route *proxyRoute) {
+		route.transport = transport
+	}
+}
+
+// WithProxy forwards requests under the path prefix to the upstream, before
+// any file lookup. Like nginx's proxy_pass, an upstream with a path replaces
+// the prefix by that path ("/api/" to "http://backend/" strips it), while an
+// upstream without one receives the path as is.
+//
+// X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto are set, WebSocket
+// upgrades pass through and unreachable or slow upstreams are answered with
+// 502 and 504 through ServeError.
+func WithProxy(prefix string, upstream string, options ...ProxyOption) ConfigFunc {
+	return func(service *Service) error {
+		upstreamURL, err := url.Parse(upstream)
+		if err != nil {
+			return err
+		}
+		if upstreamURL.Scheme != "http" && upstreamURL.Scheme != "https" || upstreamURL.Host == "" {
+			return fmt.Errorf("invalid proxy upstream: %s", upstream)
+		}
+
+		route := &proxyRoute{
+			prefix:   prefix,
+			upstream: upstreamURL,
+			timeout:  defaultProxyTimeout,
+		}
+		for _, option := range options {
+			option(route)
+		}
+
+		return WithHandler(prefix, service.proxyHandler(route))(service)
+	}
+}
+
+func (service *Service) proxyHandler(route *proxyRoute) http.Handler {
+	transport := route.transport
+	if transport == nil {
+		defaultTransport := http.DefaultTransport.(*http.Transport).Clone()
+		defaultTransport.DialContext = (&net.Dialer{Timeout: defaultProxyDialTimeout}).DialContext
+		defaultTransport.ResponseHeaderTimeout = route.timeout
+		transport = defaultTransport
+	}
+	if route.cache != nil {
+		transport = route.cache.transport(transport)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(proxyRequest *httputil.ProxyRequest) {
+			out := proxyRequest.Out
+			out.URL.Scheme = route.upstream.Scheme
+			out.URL.Host = route.upstream.Host
+			if route.upstream.Path != "" {
+				out.URL.Path = joinURLPath(route.upstream.Path, strings.TrimPrefix(proxyRequest.In.URL.Path, route.prefix))
+				out.URL.RawPath = ""
+				out.Header.Set("X-Forwarded-Prefix", mountedPath(proxyRequest.In, strings.TrimSuffix(route.prefix, "/")))
+			}
+
+			out.Host = ""
+			if route.preserveHost {
+				out.Host = proxyRequest.In.Host
+			}
+
+			proxyRequest.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			status := http.StatusBadGateway
+			var netErr net.Error
+			if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				status = http.StatusGatewayTimeout
+			}
+
+			// The client going away isn't worth reporting
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("proxy %s %s to %s: %v", r.Method, r.URL.Path, route.upstream.Host, err)
+			}
+
+			service.ServeError(w, r, status)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The upstream decides about caching, not the static file policy
+		w.Header().Del("Cache-Control")
+		w.Header().Del("Pragma")
+		w.Header().Del("Expires")
+
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+func joinURLPath(base string, rest string) string {
+	if rest == "" {
+		return base
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(rest, "/")
+}
@@ -0,0 +1,922 @@
+package poseidon
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheStatusName identifies poseidon in Cache-Status headers (RFC 9211)
+const cacheStatusName = "poseidon"
+
+// ProxyCache is a shared HTTP cache (RFC 9111) for the responses of proxy
+// routes. It stores GET responses the upstream allows a shared cache to
+// keep, revalidates them with their ETag or Last-Modified, serves them stale
+// within stale-while-revalidate and stale-if-error, and sends a single
+// request upstream for concurrent misses. Responses with Set-Cookie are never
+// stored.
+//
+// Responses stream to the client while they are stored, and responses
+// larger than the object size limit pass through. The least recently used
+// responses are evicted once the cache holds more than its budget. Responses
+// carry X-Cache (HIT, MISS, STALE, REVALIDATED or BYPASS) and Cache-Status
+// headers.
+type ProxyCache struct {
+	maxBytes       int64
+	maxObjectBytes int64
+	directory      string
+
+	mutex sync.Mutex
+	size  int64
+	// lru holds the entries, most recently used first
+	lru     *list.List
+	entries map[string]*list.Element
+	// varies holds the Vary header names of the responses by primary key
+	varies map[string][]string
+	calls  map[string]*proxyCacheCall
+}
+
+// ProxyCacheOption configures a cache created by NewProxyCache or
+// NewDiskProxyCache.
+type ProxyCacheOption func(cache *ProxyCache)
+
+// ProxyCacheMaxObjectSize limits the size of a single stored response (an
+// eighth of the budget by default).
+func ProxyCacheMaxObjectSize(maxBytes int64) ProxyCacheOption {
+	return func(cache *ProxyCache) {
+		cache.maxObjectBytes = maxBytes
+	}
+}
+
+// proxyCacheCall lets concurrent misses wait for the request of the first.
+type proxyCacheCall struct {
+	done  chan struct{}
+	entry *proxyCacheEntry
+}
+
+type proxyCacheEntry struct {
+	Key          string      `json:"key"`
+	PrimaryKey   string      `json:"primary_key"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	ResponseTime time.Time   `json:"response_time"`
+	Size         int64       `json:"size"`
+
+	// body is nil for entries kept on disk until they are read
+	body []byte
+}
+
+// NewProxyCache creates an in-memory cache holding up to maxBytes of
+// responses.
+func NewProxyCache(maxBytes int64, options ...ProxyCacheOption) *ProxyCache {
+	cache := &ProxyCache{
+		maxBytes:       maxBytes,
+		maxObjectBytes: maxBytes / 8,
+		lru:            list.New(),
+		entries:        map[string]*list.Element{},
+		varies:         map[string][]string{},
+		calls:          map[string]*proxyCacheCall{},
+	}
+	for _, option := range options {
+		option(cache)
+	}
+
+	return cache
+}
+
+// NewDiskProxyCache creates a cache keeping up to maxBytes of responses in
+// the directory instead of memory. Responses stored by a previous process
+// are used again.
+func NewDiskProxyCache(directory string, maxBytes int64, options ...ProxyCacheOption) (*ProxyCache, error) {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+
+	cache := NewProxyCache(maxBytes, options...)
+	cache.directory = directory
+
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// ProxyCaching stores the responses of the upstream in the cache, which can
+// be shared between routes.
+func ProxyCaching(cache *ProxyCache) ProxyOption {
+	return func(route *proxyRoute) {
+		route.cache = cache
+	}
+}
+
+func (cache *ProxyCache) transport(next http.RoundTripper) http.RoundTripper {
+	return &proxyCacheTransport{cache: cache, next: next}
+}
+
+type proxyCacheTransport struct {
+	cache *ProxyCache
+	next  http.RoundTripper
+}
+
+func (transport *proxyCacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	cache := transport.cache
+
+	if r.Header.Get("Upgrade") != "" {
+		return transport.next.RoundTrip(r)
+	}
+
+	primaryKey := proxyCacheKey(r)
+	requestDirectives := parseCacheDirectives(r.Header.Values("Cache-Control"))
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || r.Header.Get("Range") != "" || requestDirectives.has("no-store") {
+		response, err := transport.next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// RFC 9111 section 4.4: unsafe requests invalidate the stored responses
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && response.StatusCode < 400 {
+			cache.invalidate(primaryKey)
+		}
+
+		setCacheStatus(response.Header, "BYPASS", "fwd=bypass")
+
+		return response, nil
+	}
+
+	key := cache.variantKey(primaryKey, r)
+	entry := cache.get(key)
+	now := time.Now()
+
+	forward := "uri-miss"
+	switch {
+	case entry == nil:
+	case requestDirectives.has("no-cache") || requestDirectives.maxAge() == 0:
+		forward = "request"
+	case entry.isFresh(now):
+		return entry.response(r, "HIT", fmt.Sprintf("hit; ttl=%d", entry.ttl(now)/time.Second)), nil
+	case entry.allowsStale(now, "stale-while-revalidate"):
+		transport.revalidate(r, key, entry)
+		return entry.response(r, "STALE", fmt.Sprintf("hit; ttl=%d; detail=stale-while-revalidate", entry.ttl(now)/time.Second)), nil
+	default:
+		forward = "stale"
+	}
+
+	if r.Method == http.MethodHead {
+		return transport.forward(r, primaryKey, entry, forward, nil)
+	}
+
+	call, leader := cache.lead(key)
+	if !leader {
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+
+		// The response may vary on headers that differ in this request
+		if call.entry != nil && cache.variantKey(primaryKey, r) == call.entry.Key {
+			return call.entry.response(r, "HIT", "fwd="+forward+"; collapsed"), nil
+		}
+
+		return transport.forward(r, primaryKey, entry, forward, nil)
+	}
+
+	// The waiting requests are released once the response is stored, or
+	// here if forwarding panics before handing that over
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			cache.finish(key, call, nil)
+		}
+	}()
+
+	response, err := transport.forward(r, primaryKey, entry, forward, func(stored *proxyCacheEntry) {
+		cache.finish(key, call, stored)
+	})
+	handedOver = true
+
+	return response, err
+}
+
+// revalidate refreshes the entry in the background, unless a request for it
+// is already on its way.
+func (transport *proxyCacheTransport) revalidate(r *http.Request, key string, entry *proxyCacheEntry) {
+	call, leader := transport.cache.lead(key)
+	if !leader {
+		return
+	}
+
+	background := r.Clone(context.WithoutCancel(r.Context()))
+	primaryKey := proxyCacheKey(r)
+
+	go func() {
+		response, err := transport.forward(background, primaryKey, entry, "stale", func(stored *proxyCacheEntry) {
+			transport.cache.finish(key, call, stored)
+		})
+		if err == nil {
+			_, _ = io.Copy(io.Discard, response.Body)
+			_ = response.Body.Close()
+		}
+	}()
+}
+
+// forward sends the request upstream and stores the response when allowed.
+// done gets the stored entry or nil, once the body was read if it streams.
+func (transport *proxyCacheTransport) forward(r *http.Request, primaryKey string, entry *proxyCacheEntry, forward string, done func(stored *proxyCacheEntry)) (*http.Response, error) {
+	cache := transport.cache
+	if done == nil {
+		done = func(*proxyCacheEntry) {}
+	}
+
+	upstreamRequest := r
+	if r.Method == http.MethodGet {
+		// Conditions of the client are checked against the stored response
+		upstreamRequest = r.Clone(r.Context())
+		for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+			upstreamRequest.Header.Del(name)
+		}
+
+		if entry != nil {
+			setOrDelete(upstreamRequest.Header, "If-None-Match", entry.Header.Get("ETag"))
+			setOrDelete(upstreamRequest.Header, "If-Modified-Since", entry.Header.Get("Last-Modified"))
+		}
+	}
+
+	response, err := transport.next.RoundTrip(upstreamRequest)
+	now := time.Now()
+	if err != nil {
+		done(nil)
+		if entry != nil && entry.allowsStale(now, "stale-if-error") {
+			return entry.response(r, "STALE", "fwd="+forward+"; detail=stale-if-error"), nil
+		}
+
+		return nil, err
+	}
+
+	if entry != nil && response.StatusCode == http.StatusNotModified {
+		_ = response.Body.Close()
+
+		updated := entry.revalidated(response.Header, now)
+		cache.store(updated)
+		done(updated)
+
+		return updated.response(r, "REVALIDATED", "fwd="+forward+"; fwd-status=304"), nil
+	}
+
+	if entry != nil && response.StatusCode >= 500 && entry.allowsStale(now, "stale-if-error") {
+		_ = response.Body.Close()
+		done(nil)
+
+		return entry.response(r, "STALE", fmt.Sprintf("fwd=%s; fwd-status=%d; detail=stale-if-error", forward, response.StatusCode)), nil
+	}
+
+	detail := fmt.Sprintf("fwd=%s; fwd-status=%d", forward, response.StatusCode)
+
+	limit := cache.maxObjectBytes - headerSize(response.Header)
+	if !isStorable(r, response) || response.ContentLength > limit {
+		done(nil)
+		setCacheStatus(response.Header, "MISS", detail)
+		return response, nil
+	}
+
+	stored := &proxyCacheEntry{
+		PrimaryKey:   primaryKey,
+		Status:       response.StatusCode,
+		Header:       response.Header.Clone(),
+		ResponseTime: now,
+	}
+	stored.Key = cache.varyKey(primaryKey, varyNames(stored.Header), r)
+
+	body := newProxyCacheBody(response.Body, limit, func(content []byte) {
+		if content == nil {
+			done(nil)
+			return
+		}
+
+		stored.body = content
+		cache.store(stored)
+		done(stored)
+	})
+	response.Body = body
+
+	// The conditions of the client were left out upstream, so a client that
+	// has the response gets a 304 once the body is stored
+	if stored.notModified(r) {
+		_, _ = io.Copy(io.Discard, body)
+		_ = body.Close()
+
+		return stored.response(r, "MISS", detail), nil
+	}
+
+	setCacheStatus(response.Header, "MISS", detail)
+
+	return response, nil
+}
+
+// proxyCacheBody reads the upstream body into a copy on its own, so the
+// requests waiting for it don't depend on how fast the client reads. The
+// client reads from the copy, and directly from upstream once the body grew
+// over the limit.
+type proxyCacheBody struct {
+	upstream io.ReadCloser
+	limit    int64
+	done     func(content []byte)
+	once     sync.Once
+
+	mutex sync.Mutex
+	ready *sync.Cond
+	// content is what was read from upstream, offset what the client got
+	content []byte
+	offset  int
+	// err stopped the reading from upstream, errProxyCacheOverLimit hands it
+	// over to the client
+	err    error
+	closed bool
+}
+
+var errProxyCacheOverLimit = errors.New("the body is over the limit")
+
+func newProxyCacheBody(upstream io.ReadCloser, limit int64, done func(content []byte)) *proxyCacheBody {
+	body := &proxyCacheBody{
+		upstream: upstream,
+		limit:    limit,
+		done:     done,
+	}
+	body.ready = sync.NewCond(&body.mutex)
+
+	go body.fill()
+
+	return body
+}
+
+func (body *proxyCacheBody) fill() {
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := body.upstream.Read(chunk)
+
+		body.mutex.Lock()
+		body.content = append(body.content, chunk[:n]...)
+		overLimit := int64(len(body.content)) > body.limit
+		if err == nil && overLimit {
+			err = errProxyCacheOverLimit
+		}
+		body.err = err
+		body.ready.Broadcast()
+		body.mutex.Unlock()
+
+		switch {
+		case err == io.EOF && !overLimit:
+			body.finish(body.content)
+			return
+		case err != nil:
+			body.finish(nil)
+			return
+		}
+	}
+}
+
+func (body *proxyCacheBody) Read(p []byte) (int, error) {
+	body.mutex.Lock()
+	for body.offset == len(body.content) && body.err == nil && !body.closed {
+		body.ready.Wait()
+	}
+
+	if body.closed {
+		body.mutex.Unlock()
+		return 0, http.ErrBodyReadAfterClose
+	}
+
+	if body.offset < len(body.content) {
+		n := copy(p, body.content[body.offset:])
+		body.offset += n
+		body.mutex.Unlock()
+
+		return n, nil
+	}
+
+	err := body.err
+	body.mutex.Unlock()
+
+	if err == errProxyCacheOverLimit {
+		return body.upstream.Read(p)
+	}
+
+	return 0, err
+}
+
+func (body *proxyCacheBody) Close() error {
+	body.mutex.Lock()
+	body.closed = true
+	body.ready.Broadcast()
+	body.mutex.Unlock()
+
+	// Bodies closed before the end aren't stored, as reading them fails
+	return body.upstream.Close()
+}
+
+func (body *proxyCacheBody) finish(content []byte) {
+	body.once.Do(func() {
+		body.done(content)
+	})
+}
+
+// lead registers a request for the key, or returns the one already running.
+func (cache *ProxyCache) lead(key string) (*proxyCacheCall, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if call, found := cache.calls[key]; found {
+		return call, false
+	}
+
+	call := &proxyCacheCall{done: make(chan struct{})}
+	cache.calls[key] = call
+
+	return call, true
+}
+
+// finish hands the stored entry, if any, to the requests waiting for it.
+// Calls after the first do nothing.
+func (cache *ProxyCache) finish(key string, call *proxyCacheCall, entry *proxyCacheEntry) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return
+	default:
+	}
+
+	call.entry = entry
+	delete(cache.calls, key)
+	close(call.done)
+}
+
+// get returns the entry for the key, with its body, and marks it as used.
+func (cache *ProxyCache) get(key string) *proxyCacheEntry {
+	cache.mutex.Lock()
+	element, found := cache.entries[key]
+	if !found {
+		cache.mutex.Unlock()
+		return nil
+	}
+	cache.lru.MoveToFront(element)
+	entry := element.Value.(*proxyCacheEntry)
+	cache.mutex.Unlock()
+
+	if entry.body != nil || cache.directory == "" {
+		return entry
+	}
+
+	body, err := os.ReadFile(cache.path(entry.Key, ".body"))
+	if err != nil {
+		cache.remove(entry.Key)
+		return nil
+	}
+
+	withBody := *entry
+	withBody.body = body
+
+	return &withBody
+}
+
+func (cache *ProxyCache) store(entry *proxyCacheEntry) {
+	entry.Size = int64(len(entry.body)) + headerSize(entry.Header)
+	if entry.Size > cache.maxBytes {
+		return
+	}
+
+	kept := entry
+	if cache.directory != "" {
+		if err := cache.write(entry); err != nil {
+			return
+		}
+
+		withoutBody := *entry
+		withoutBody.body = nil
+		kept = &withoutBody
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, found := cache.entries[entry.Key]; found {
+		cache.size -= element.Value.(*proxyCacheEntry).Size
+		cache.lru.Remove(element)
+	}
+
+	cache.entries[entry.Key] = cache.lru.PushFront(kept)
+	cache.varies[entry.PrimaryKey] = varyNames(entry.Header)
+	cache.size += entry.Size
+
+	cache.evict()
+}
+
+// evict drops the least recently used entries until the cache fits its
+// budget. The mutex must be held.
+func (cache *ProxyCache) evict() {
+	for cache.size > cache.maxBytes {
+		element := cache.lru.Back()
+		if element == nil {
+			return
+		}
+
+		cache.removeElement(element)
+	}
+}
+
+// removeElement drops an entry. The mutex must be held.
+func (cache *ProxyCache) removeElement(element *list.Element) {
+	entry := element.Value.(*proxyCacheEntry)
+	cache.lru.Remove(element)
+	delete(cache.entries, entry.Key)
+	cache.size -= entry.Size
+
+	if cache.directory != "" {
+		_ = os.Remove(cache.path(entry.Key, ".json"))
+		_ = os.Remove(cache.path(entry.Key, ".body"))
+	}
+}
+
+func (cache *ProxyCache) remove(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, found := cache.entries[key]; found {
+		cache.removeElement(element)
+	}
+}
+
+// invalidate drops every variant stored for the primary key.
+func (cache *ProxyCache) invalidate(primaryKey string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for element := cache.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*proxyCacheEntry).PrimaryKey == primaryKey {
+			cache.removeElement(element)
+		}
+		element = next
+	}
+	delete(cache.varies, primaryKey)
+}
+
+// variantKey returns the key of the stored variant matching the request.
+func (cache *ProxyCache) variantKey(primaryKey string, r *http.Request) string {
+	cache.mutex.Lock()
+	names := cache.varies[primaryKey]
+	cache.mutex.Unlock()
+
+	return cache.varyKey(primaryKey, names, r)
+}
+
+func (cache *ProxyCache) varyKey(primaryKey string, names []string, r *http.Request) string {
+	key := strings.Builder{}
+	key.WriteString(primaryKey)
+	for _, name := range names {
+		key.WriteString("\n" + name + ": " + strings.Join(r.Header.Values(name), ", "))
+	}
+
+	return key.String()
+}
+
+func (cache *ProxyCache) path(key string, extension string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return filepath.Join(cache.directory, hex.EncodeToString(hash[:])+extension)
+}
+
+// write saves the body and then the metadata, each replacing the previous
+// file at once.
+func (cache *ProxyCache) write(entry *proxyCacheEntry) error {
+	metadata, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(cache.path(entry.Key, ".body"), entry.body); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cache.path(entry.Key, ".json"), metadata)
+}
+
+func writeFileAtomic(name string, content []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), name)
+}
+
+// load indexes the responses stored in the directory, oldest first.
+func (cache *ProxyCache) load() error {
+	names, err := filepath.Glob(filepath.Join(cache.directory, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	entries := []*proxyCacheEntry{}
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		entry := &proxyCacheEntry{}
+		if err := json.Unmarshal(content, entry); err != nil || cache.path(entry.Key, ".json") != name {
+			// Leftovers of an interrupted write
+			_ = os.Remove(name)
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a *proxyCacheEntry, b *proxyCacheEntry) int {
+		return a.ResponseTime.Compare(b.ResponseTime)
+	})
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for _, entry := range entries {
+		cache.entries[entry.Key] = cache.lru.PushFront(entry)
+		cache.varies[entry.PrimaryKey] = varyNames(entry.Header)
+		cache.size += entry.Size
+	}
+	cache.evict()
+
+	return nil
+}
+
+// proxyCacheKey identifies the upstream resource of a request.
+func proxyCacheKey(r *http.Request) string {
+	return r.Host + " " + r.URL.String()
+}
+
+func varyNames(header http.Header) []string {
+	names := []string{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return names
+}
+
+func headerSize(header http.Header) int64 {
+	size := 0
+	for name, values := range header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+
+	return int64(size)
+}
+
+// isStorable checks whether a shared cache may store the response, following
+// RFC 9111 section 3.
+func isStorable(r *http.Request, response *http.Response) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	directives := parseCacheDirectives(response.Header.Values("Cache-Control"))
+	if directives.has("no-store") || directives.has("private") || response.Header.Get("Set-Cookie") != "" || slices.Contains(varyNames(response.Header), "*") {
+		return false
+	}
+
+	if r.Header.Get("Authorization") != "" && !directives.has("public") && !directives.has("s-maxage") && !directives.has("must-revalidate") {
+		return false
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices, http.StatusMovedPermanently,
+		http.StatusPermanentRedirect, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone, http.StatusRequestURITooLong,
+		http.StatusNotImplemented:
+	default:
+		return false
+	}
+
+	// Without a lifetime, a validator still saves the transfer
+	return directives.has("max-age") || directives.has("s-maxage") || directives.has("public") ||
+		response.Header.Get("Expires") != "" || response.Header.Get("ETag") != "" || response.Header.Get("Last-Modified") != ""
+}
+
+// lifetime is the freshness lifetime of RFC 9111 section 4.2.1.
+func (entry *proxyCacheEntry) lifetime() time.Duration {
+	directives := parseCacheDirectives(entry.Header.Values("Cache-Control"))
+	if directives.has("no-cache") {
+		return 0
+	}
+	if maxAge, found := directives.seconds("s-maxage"); found {
+		return maxAge
+	}
+	if maxAge, found := directives.seconds("max-age"); found {
+		return maxAge
+	}
+
+	if value := entry.Header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			return 0
+		}
+
+		date, err := http.ParseTime(entry.Header.Get("Date"))
+		if err != nil {
+			date = entry.ResponseTime
+		}
+
+		return expires.Sub(date)
+	}
+
+	return 0
+}
+
+// age is the current age of RFC 9111 section 4.2.3.
+func (entry *proxyCacheEntry) age(now time.Time) time.Duration {
+	age := time.Duration(0)
+	if seconds, err := strconv.Atoi(entry.Header.Get("Age")); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+
+	return age + now.Sub(entry.ResponseTime)
+}
+
+func (entry *proxyCacheEntry) ttl(now time.Time) time.Duration {
+	return entry.lifetime() - entry.age(now)
+}
+
+func (entry *proxyCacheEntry) isFresh(now time.Time) bool {
+	return entry.ttl(now) > 0
+}
+
+// allowsStale checks whether the stale entry can still be served under the
+// stale-while-revalidate or stale-if-error directive.
+func (entry *proxyCacheEntry) allowsStale(now time.Time, directive string) bool {
+	directives := parseCacheDirectives(entry.Header.Values("Cache-Control"))
+	if directives.has("no-cache") || directives.has("must-revalidate") || directives.has("proxy-revalidate") || directives.has("s-maxage") {
+		return false
+	}
+
+	window, found := directives.seconds(directive)
+
+	return found && -entry.ttl(now) < window
+}
+
+// revalidated applies the headers of a 304 response to the entry.
+func (entry *proxyCacheEntry) revalidated(header http.Header, now time.Time) *proxyCacheEntry {
+	updated := *entry
+	updated.Header = entry.Header.Clone()
+	updated.ResponseTime = now
+
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Content-Type", "Transfer-Encoding":
+			continue
+		}
+
+		updated.Header[name] = values
+	}
+	if header.Get("Age") == "" {
+		updated.Header.Del("Age")
+	}
+
+	return &updated
+}
+
+// response answers the request from the entry, with a 304 if the conditions
+// of the request say the client has it already.
+func (entry *proxyCacheEntry) response(r *http.Request, status string, detail string) *http.Response {
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(entry.age(time.Now())/time.Second), 10))
+	header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+	setCacheStatus(header, status, detail)
+
+	response := &http.Response{
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		ContentLength: int64(len(entry.body)),
+		Body:          io.NopCloser(bytes.NewReader(entry.body)),
+		Request:       r,
+	}
+
+	if entry.notModified(r) {
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			header.Del(name)
+		}
+		response.StatusCode = http.StatusNotModified
+		response.ContentLength = 0
+		response.Body = http.NoBody
+	}
+
+	if r.Method == http.MethodHead {
+		response.Body = http.NoBody
+	}
+
+	response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+
+	return response
+}
+
+// notModified checks the conditions of the request against the entry.
+func (entry *proxyCacheEntry) notModified(r *http.Request) bool {
+	lastModified, _ := http.ParseTime(entry.Header.Get("Last-Modified"))
+	result := checkIfNoneMatch(r, entry.Header.Get("ETag"))
+	if result == conditionNone {
+		result = checkIfModifiedSince(r, lastModified)
+	}
+
+	return entry.Status == http.StatusOK && result == conditionFalse
+}
+
+func setCacheStatus(header http.Header, status string, detail string) {
+	header.Set("X-Cache", status)
+	header.Add("Cache-Status", cacheStatusName+"; "+detail)
+}
+
+type cacheDirectives map[string]string
+
+// parseCacheDirectives reads Cache-Control values without failing on
+// directives it doesn't know, unlike ParseCachePolicy.
+func parseCacheDirectives(values []string) cacheDirectives {
+	directives := cacheDirectives{}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(textproto.TrimString(name))
+			if name != "" {
+				directives[name] = strings.Trim(textproto.TrimString(argument), `"`)
+			}
+		}
+	}
+
+	return directives
+}
+
+func (directives cacheDirectives) has(name string) bool {
+	_, found := directives[name]
+
+	return found
+}
+
+func (directives cacheDirectives) seconds(name string) (time.Duration, bool) {
+	argument, found := directives[name]
+	if !found {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(argument, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// maxAge returns the max-age of a request, or -1 without one.
+func (directives cacheDirectives) maxAge() time.Duration {
+	if maxAge, found := directives.seconds("max-age"); found {
+		return maxAge
+	}
+
+	return -1
+}
@@ -0,0 +1,272 @@
+package poseidon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+func (r byteRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	return header
+}
+
+// parseRange parses a Range header (RFC 9110 section 14.1.2). A nil slice
+// without an error means the full representation is served.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	// Unknown range units must be ignored
+	specifier, found := strings.CutPrefix(value, "bytes=")
+	if !found {
+		return nil, nil
+	}
+
+	ranges := []byteRange{}
+	for _, part := range strings.Split(specifier, ",") {
+		part = textproto.TrimString(part)
+		if part == "" {
+			continue
+		}
+
+		startValue, endValue, found := strings.Cut(part, "-")
+		if !found {
+			return nil, nil
+		}
+		startValue = textproto.TrimString(startValue)
+		endValue = textproto.TrimString(endValue)
+
+		var byteRange byteRange
+		if startValue == "" {
+			// Suffix range such as "-500" (the last 500 bytes)
+			suffix, err := strconv.ParseInt(endValue, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, nil
+			}
+			if suffix == 0 {
+				continue
+			}
+			suffix = min(suffix, size)
+			byteRange.start = size - suffix
+			byteRange.length = suffix
+		} else {
+			start, err := strconv.ParseInt(startValue, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			if start >= size {
+				continue
+			}
+
+			byteRange.start = start
+			byteRange.length = size - start
+			if endValue != "" {
+				end, err := strconv.ParseInt(endValue, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+				byteRange.length = min(end, size-1) - start + 1
+			}
+		}
+
+		ranges = append(ranges, byteRange)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errRangeNotSatisfiable
+	}
+
+	// Asking for more than the whole file is most likely abusive, so just
+	// send the full representation instead
+	total := int64(0)
+	for _, byteRange := range ranges {
+		total += byteRange.length
+	}
+	if total > size {
+		return nil, nil
+	}
+
+	return ranges, nil
+}
+
+// checkIfRange reports whether the Range header should be honored according
+// to the If-Range header.
+func checkIfRange(r *http.Request, etag string, modTime time.Time) bool {
+	value := r.Header.Get("If-Range")
+	if value == "" {
+		return true
+	}
+
+	if strings.HasPrefix(value, "\"") || strings.HasPrefix(value, "W/") {
+		return strongETagMatch(value, etag)
+	}
+
+	if modTime.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(value)
+	if err != nil {
+		return false
+	}
+
+	return modTime.Truncate(time.Second).Equal(since)
+}
+
+// writeRanges serves the requested byte ranges of the file. It returns false
+// when the request should be answered with the full representation instead.
+func writeRanges(w http.ResponseWriter, r *http.Request, file fs.File, stat fs.FileInfo, etag string, contentType string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if r.Header.Get("Range") == "" || !checkIfRange(r, etag, stat.ModTime()) {
+		return false
+	}
+
+	size := stat.Size()
+	ranges, err := parseRange(r.Header.Get("Range"), size)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	if len(ranges) == 0 {
+		return false
+	}
+
+	if len(ranges) == 1 {
+		byteRange := ranges[0]
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Content-Range", byteRange.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(byteRange.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+
+		if r.Method == http.MethodHead {
+			return true
+		}
+
+		if err := skipTo(file, byteRange.start); err != nil {
+			return true
+		}
+		_, _ = io.CopyN(w, file, byteRange.length)
+
+		return true
+	}
+
+	// Multiple ranges may be requested in any order, so random access is needed
+	reader, err := asReadSeeker(file)
+	if err != nil {
+		return false
+	}
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.Header().Set("Content-Length", strconv.FormatInt(multipartSize(ranges, boundary, contentType, size), 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	parts := multipart.NewWriter(w)
+	_ = parts.SetBoundary(boundary)
+	for _, byteRange := range ranges {
+		part, err := parts.CreatePart(byteRange.mimeHeader(contentType, size))
+		if err != nil {
+			return true
+		}
+
+		if _, err := reader.Seek(byteRange.start, io.SeekStart); err != nil {
+			return true
+		}
+
+		if _, err := io.CopyN(part, reader, byteRange.length); err != nil {
+			return true
+		}
+	}
+	_ = parts.Close()
+
+	return true
+}
+
+// multipartSize calculates the exact size of a multipart/byteranges body so
+// the Content-Length can be sent ahead of the body.
+func multipartSize(ranges []byteRange, boundary string, contentType string, size int64) int64 {
+	counter := &countingWriter{}
+	parts := multipart.NewWriter(counter)
+	_ = parts.SetBoundary(boundary)
+	for _, byteRange := range ranges {
+		_, _ = parts.CreatePart(byteRange.mimeHeader(contentType, size))
+		counter.count += byteRange.length
+	}
+	_ = parts.Close()
+
+	return counter.count
+}
+
+type countingWriter struct {
+	count int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.count += int64(len(b))
+
+	return len(b), nil
+}
+
+// skipTo moves the file to the given offset, seeking when possible and
+// reading past the leading bytes otherwise.
+func skipTo(file fs.File, offset int64) error {
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+
+	_, err := io.CopyN(io.Discard, file, offset)
+
+	return err
+}
+
+func asReadSeeker(file fs.File) (io.ReadSeeker, error) {
+	if readSeeker, ok := file.(io.ReadSeeker); ok {
+		return readSeeker, nil
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(content), nil
+}
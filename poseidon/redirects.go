@@ -0,0 +1,470 @@
+package poseidon
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const redirectsFile = "_redirects"
+
+// countryHeaders are set by CDNs and proxies with the country of the client
+var countryHeaders = []string{"CF-IPCountry", "CloudFront-Viewer-Country", "X-Vercel-IP-Country", "X-Country-Code"}
+
+var placeholderPattern = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+
+// redirectRule redirects or rewrites requests matching its pattern, with its
+// captures available in the target.
+type redirectRule struct {
+	method       string
+	host         string
+	pattern      *regexp.Regexp
+	query        map[string]string
+	to           string
+	placeholders *regexp.Regexp
+	status       int
+	force        bool
+	conditions   []RequestMatcher
+	// mergesQuery keeps the parameters of the request the target doesn't set
+	mergesQuery bool
+}
+
+// match returns the target for the request if the rule applies to it.
+func (rule redirectRule) match(r *http.Request) (string, bool) {
+	if rule.method != "" && rule.method != r.Method && (rule.method != http.MethodGet || r.Method != http.MethodHead) {
+		return "", false
+	}
+
+	if rule.host != "" && !strings.EqualFold(rule.host, hostWithoutPort(r.Host)) {
+		return "", false
+	}
+
+	captures, found := matchPath(rule.pattern, r.URL.Path)
+	if !found {
+		return "", false
+	}
+
+	query := r.URL.Query()
+	for key, value := range rule.query {
+		if !query.Has(key) {
+			return "", false
+		}
+
+		if name, isPlaceholder := strings.CutPrefix(value, ":"); isPlaceholder {
+			captures[name] = query.Get(key)
+		} else if query.Get(key) != value {
+			return "", false
+		}
+	}
+
+	for _, condition := range rule.conditions {
+		if !condition(r) {
+			return "", false
+		}
+	}
+
+	// Captures can't turn a path into a URL on another host
+	target := expandPlaceholders(rule.to, captures, rule.placeholders)
+	if isProtocolRelative(target) && !isProtocolRelative(rule.to) {
+		return "", false
+	}
+
+	return target, true
+}
+
+// isProtocolRelative reports whether browsers read the target as a URL on
+// another host.
+func isProtocolRelative(target string) bool {
+	return strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\")
+}
+
+// matchPath returns the captures of the pattern for the path, by name and by
+// number.
+func matchPath(pattern *regexp.Regexp, urlPath string) (map[string]string, bool) {
+	match := pattern.FindStringSubmatch(urlPath)
+	if match == nil {
+		return nil, false
+	}
+
+	captures := map[string]string{}
+	for i, name := range pattern.SubexpNames() {
+		if i == 0 {
+			continue
+		}
+
+		captures[strconv.Itoa(i)] = match[i]
+		if name != "" {
+			captures[name] = match[i]
+		}
+	}
+
+	return captures, true
+}
+
+// expandPlaceholders replaces the placeholders by the capture they name.
+func expandPlaceholders(template string, captures map[string]string, placeholders *regexp.Regexp) string {
+	return placeholders.ReplaceAllStringFunc(template, func(placeholder string) string {
+		for _, name := range placeholders.FindStringSubmatch(placeholder)[1:] {
+			if value, found := captures[name]; name != "" && found {
+				return value
+			}
+		}
+
+		return placeholder
+	})
+}
+
+func hostWithoutPort(host string) string {
+	if index := strings.LastIndex(host, ":"); index > strings.LastIndex(host, "]") {
+		return host[:index]
+	}
+
+	return host
+}
+
+// compilePathPattern turns paths like /blog/:year/* into a regular expression
+// capturing the placeholders and the splat, which has to come last.
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	return compileSplatPattern(pattern, false)
+}
+
+// compileHeaderPathPattern also accepts splats inside the path, such as
+// /*.html in _headers files. The first one is captured.
+func compileHeaderPathPattern(pattern string) (*regexp.Regexp, error) {
+	return compileSplatPattern(pattern, true)
+}
+
+func compileSplatPattern(pattern string, splatsAnywhere bool) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("path must start with a slash: %s", pattern)
+	}
+
+	expression := strings.Builder{}
+	expression.WriteString("^")
+
+	captured := false
+	splat := func() string {
+		if captured {
+			return `.*`
+		}
+		captured = true
+
+		return `(?P<splat>.*)`
+	}
+
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch {
+		case segment == "*" && last:
+			// A final splat also matches the directory itself
+			expression.WriteString(`(?:/` + splat() + `)?`)
+		case strings.Contains(segment, "*") && (splatsAnywhere || last && strings.Index(segment, "*") == len(segment)-1):
+			expression.WriteString("/")
+			for j, part := range strings.Split(segment, "*") {
+				if j > 0 {
+					expression.WriteString(splat())
+				}
+				expression.WriteString(regexp.QuoteMeta(part))
+			}
+		case strings.Contains(segment, "*"):
+			return nil, fmt.Errorf("splats are only allowed at the end: %s", pattern)
+		case strings.HasPrefix(segment, ":"):
+			if placeholderPattern.FindString(segment) != segment {
+				return nil, fmt.Errorf("invalid placeholder: %s", segment)
+			}
+			expression.WriteString(`/(?P<` + segment[1:] + `>[^/]+)`)
+		case segment == "":
+		default:
+			expression.WriteString("/" + regexp.QuoteMeta(segment))
+		}
+	}
+
+	// Trailing slashes don't matter
+	expression.WriteString(`/?$`)
+
+	return regexp.Compile(expression.String())
+}
+
+// parseRedirects reads rules in the format of Netlify's _redirects file:
+//
+//	/from [key=value...] /to [status[!]] [Country=x,y] [Language=x,y] [Cookie=x,y]
+//
+// Invalid lines are returned as errors and left out.
+func parseRedirects(content string) ([]redirectRule, []error) {
+	rules := []redirectRule{}
+	errs := []error{}
+
+	for number, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		rule, err := parseRedirectLine(fields)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", number+1, err))
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, errs
+}
+
+func parseRedirectLine(fields []string) (redirectRule, error) {
+	rule := redirectRule{
+		query:        map[string]string{},
+		placeholders: placeholderPattern,
+		status:       http.StatusMovedPermanently,
+	}
+
+	if len(fields) < 2 {
+		return rule, errors.New("missing target")
+	}
+
+	from := fields[0]
+	if strings.HasPrefix(from, "http://") || strings.HasPrefix(from, "https://") {
+		fromURL, err := url.Parse(from)
+		if err != nil {
+			return rule, err
+		}
+		rule.host = fromURL.Hostname()
+		from = fromURL.Path
+		if from == "" {
+			from = "/"
+		}
+	}
+
+	pattern, err := compilePathPattern(from)
+	if err != nil {
+		return rule, err
+	}
+	rule.pattern = pattern
+
+	// Query parameters to match come between the paths
+	fields = fields[1:]
+	for len(fields) > 0 && !isRedirectTarget(fields[0]) {
+		key, value, found := strings.Cut(fields[0], "=")
+		if !found {
+			return rule, fmt.Errorf("invalid target: %s", fields[0])
+		}
+		rule.query[key] = value
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return rule, errors.New("missing target")
+	}
+	rule.to = fields[0]
+	fields = fields[1:]
+
+	if len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		status, forced := strings.CutSuffix(fields[0], "!")
+		rule.force = forced
+		rule.status, err = strconv.Atoi(status)
+		if err != nil {
+			return rule, fmt.Errorf("invalid status: %s", fields[0])
+		}
+		fields = fields[1:]
+	}
+
+	switch {
+	case isRedirectStatus(rule.status):
+	case rule.status == http.StatusOK:
+		if !strings.HasPrefix(rule.to, "/") {
+			return rule, fmt.Errorf("rewrites to other hosts are not supported: %s", rule.to)
+		}
+	case rule.status >= 400 && rule.status <= 499:
+		if !strings.HasPrefix(rule.to, "/") {
+			return rule, fmt.Errorf("status %d needs a file to serve: %s", rule.status, rule.to)
+		}
+	default:
+		return rule, fmt.Errorf("unsupported status: %d", rule.status)
+	}
+
+	for _, field := range fields {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return rule, fmt.Errorf("invalid condition: %s", field)
+		}
+
+		values := strings.Split(strings.ToLower(value), ",")
+		switch strings.ToLower(key) {
+		case "country":
+			rule.conditions = append(rule.conditions, matchCountry(values))
+		case "language":
+			rule.conditions = append(rule.conditions, matchLanguage(values))
+		case "cookie":
+			rule.conditions = append(rule.conditions, matchCookie(strings.Split(value, ",")))
+		default:
+			return rule, fmt.Errorf("unsupported condition: %s", key)
+		}
+	}
+
+	return rule, nil
+}
+
+func isRedirectTarget(field string) bool {
+	return strings.HasPrefix(field, "/") || strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://")
+}
+
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+func matchCountry(countries []string) RequestMatcher {
+	return func(r *http.Request) bool {
+		for _, header := range countryHeaders {
+			if country := strings.ToLower(r.Header.Get(header)); country != "" {
+				return containsFold(countries, country)
+			}
+		}
+
+		return false
+	}
+}
+
+func matchLanguage(languages []string) RequestMatcher {
+	return func(r *http.Request) bool {
+		for _, value := range parseQualityList(r.Header.Get("Accept-Language")) {
+			primary, _, _ := strings.Cut(value.Value, "-")
+			if value.Quality > 0 && (containsFold(languages, value.Value) || containsFold(languages, primary)) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func matchCookie(names []string) RequestMatcher {
+	return func(r *http.Request) bool {
+		for _, name := range names {
+			if _, err := r.Cookie(name); err == nil {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithRedirectsFile applies the rules of a Netlify style _redirects file at
+// the root of the file system. Invalid lines are logged and skipped, and the
+// file itself is never served.
+func WithRedirectsFile() ConfigFunc {
+	return func(service *Service) error {
+		service.deniedPaths = append(service.deniedPaths, "/"+redirectsFile)
+
+		content, err := fs.ReadFile(service.fileSystem, redirectsFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rules, errs := parseRedirects(string(content))
+		for _, err := range errs {
+			log.Printf("%s: %v", redirectsFile, err)
+		}
+
+		service.redirectRules = append(service.redirectRules, rules...)
+
+		return nil
+	}
+}
+
+// serveRedirectRules applies the first matching rule. Files shadow rules
+// that aren't forced.
+func (service *Service) serveRedirectRules(w http.ResponseWriter, r *http.Request) bool {
+	// Rules apply once, not to their own rewrites
+	if isRewritten(r) {
+		return false
+	}
+
+	for _, rule := range service.redirectRules {
+		target, found := rule.match(r)
+		if !found {
+			continue
+		}
+
+		if !rule.force && service.resolve(r).path != "" {
+			return false
+		}
+
+		targetPath, targetQuery, hasQuery := strings.Cut(target, "?")
+		query := r.URL.RawQuery
+		if rule.mergesQuery {
+			query = mergeQuery(targetQuery, query)
+		} else if hasQuery {
+			query = targetQuery
+		}
+
+		switch {
+		case isRedirectStatus(rule.status):
+			if query != "" {
+				targetPath += "?" + query
+			}
+			doNotCache(w)
+			http.Redirect(w, r, mountedPath(r, targetPath), rule.status)
+		case rule.status == http.StatusOK:
+			rewritten := rewriteRequest(r, targetPath)
+			rewritten.URL.RawQuery = query
+			service.ServeHTTP(w, rewritten)
+		default:
+			if !FallbackFile(strings.TrimPrefix(targetPath, "/"), rule.status)(service, w, r) {
+				service.ServeError(w, r, rule.status)
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// mergeQuery adds the parameters of the original query that the target
+// doesn't set itself.
+func mergeQuery(target string, original string) string {
+	targetValues, _ := url.ParseQuery(target)
+
+	merged := []string{}
+	if target != "" {
+		merged = append(merged, target)
+	}
+	for _, pair := range strings.Split(original, "&") {
+		key, _, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(key)
+		if pair == "" || err != nil || targetValues.Has(key) {
+			continue
+		}
+
+		merged = append(merged, pair)
+	}
+
+	return strings.Join(merged, "&")
+}
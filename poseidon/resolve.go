@@ -0,0 +1,240 @@
+package poseidon
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+type TrailingSlashPolicy int
+
+const (
+	// TrailingSlashAdd redirects directories to their path with a trailing slash
+	TrailingSlashAdd TrailingSlashPolicy = iota
+	// TrailingSlashStrip redirects paths with a trailing slash to the path without it
+	TrailingSlashStrip
+	// TrailingSlashIgnore serves paths with and without a trailing slash alike
+	TrailingSlashIgnore
+)
+
+func ParseTrailingSlashPolicy(value string) (TrailingSlashPolicy, bool) {
+	switch value {
+	case "add":
+		return TrailingSlashAdd, true
+	case "strip":
+		return TrailingSlashStrip, true
+	case "ignore":
+		return TrailingSlashIgnore, true
+	default:
+		return TrailingSlashAdd, false
+	}
+}
+
+func WithTrailingSlash(policy TrailingSlashPolicy) ConfigFunc {
+	return func(service *Service) error {
+		service.trailingSlash = policy
+		service.trailingSlashStatus = http.StatusPermanentRedirect
+
+		return nil
+	}
+}
+
+// WithCleanURLs resolves extensionless paths to their ".html" file, so
+// "/about" serves "about.html".
+func WithCleanURLs() ConfigFunc {
+	return func(service *Service) error {
+		service.cleanURLs = true
+
+		return nil
+	}
+}
+
+// WithCleanURLRedirects enables clean URLs and permanently redirects paths
+// ending in ".html" to their clean form.
+func WithCleanURLRedirects() ConfigFunc {
+	return func(service *Service) error {
+		service.cleanURLs = true
+		service.cleanURLRedirects = true
+
+		return nil
+	}
+}
+
+type resolution struct {
+	// path is the file to serve, empty when nothing was found
+	path string
+	// redirect is the path to redirect to, if any
+	redirect string
+	status   int
+}
+
+// resolve maps the request path onto a file in the file system, taking the
+// index files, clean URLs and the trailing slash policy into account.
+func (service *Service) resolve(r *http.Request) resolution {
+	resolution := service.resolvePath(r)
+	if !isRewritten(r) {
+		return resolution
+	}
+
+	// Rewritten requests are served internally and never redirected
+	for attempt := 0; attempt < 3 && resolution.redirect != ""; attempt++ {
+		resolution = service.resolvePath(rewriteRequest(r, resolution.redirect))
+	}
+	resolution.redirect = ""
+
+	return resolution
+}
+
+func (service *Service) resolvePath(r *http.Request) resolution {
+	// Redirects to "//host" would leave the site
+	urlPath := "/" + strings.TrimLeft(r.URL.Path, "/")
+
+	trailingSlash := strings.HasSuffix(urlPath, "/")
+	name := strings.Trim(urlPath, "/")
+	if name == "" {
+		name = "."
+	}
+
+	stat, err := fs.Stat(service.fileSystem, name)
+	isDir := err == nil && stat.IsDir()
+	isFile := err == nil && !stat.IsDir()
+
+	if isDir {
+		indexPath := service.findIndex(name)
+
+		if !trailingSlash && service.trailingSlash == TrailingSlashAdd {
+			return resolution{redirect: urlPath + "/", status: service.trailingSlashStatus}
+		}
+
+		if trailingSlash && name != "." && service.trailingSlash == TrailingSlashStrip && indexPath != "" {
+			return resolution{redirect: strings.TrimSuffix(urlPath, "/"), status: service.trailingSlashStatus}
+		}
+
+		if indexPath != "" {
+			return resolution{path: indexPath}
+		}
+
+		// Directories without an index may still have a matching ".html" file
+		if !trailingSlash {
+			if htmlPath := service.findCleanURL(name); htmlPath != "" {
+				return resolution{path: htmlPath}
+			}
+		}
+
+		return resolution{}
+	}
+
+	if isFile {
+		if trailingSlash {
+			switch service.trailingSlash {
+			case TrailingSlashStrip:
+				return resolution{redirect: strings.TrimSuffix(urlPath, "/"), status: service.trailingSlashStatus}
+			case TrailingSlashIgnore:
+				return resolution{path: name}
+			default:
+				return resolution{}
+			}
+		}
+
+		// The clean URL may resolve to a directory of the same name instead
+		if service.cleanURLRedirects {
+			if cleanPath, ok := service.cleanURLFor(urlPath); ok && service.resolvePath(rewriteRequest(r, cleanPath)).path == name {
+				return resolution{redirect: cleanPath, status: http.StatusPermanentRedirect}
+			}
+		}
+
+		return resolution{path: name}
+	}
+
+	if htmlPath := service.findCleanURL(name); htmlPath != "" {
+		if trailingSlash && service.trailingSlash == TrailingSlashStrip {
+			return resolution{redirect: strings.TrimSuffix(urlPath, "/"), status: service.trailingSlashStatus}
+		}
+
+		return resolution{path: htmlPath}
+	}
+
+	return resolution{}
+}
+
+// findIndex returns the first index candidate that exists in the directory.
+func (service *Service) findIndex(directory string) string {
+	for _, index := range service.indexes {
+		indexPath := path.Join(directory, index)
+		if stat, err := fs.Stat(service.fileSystem, indexPath); err == nil && !stat.IsDir() {
+			return indexPath
+		}
+	}
+
+	return ""
+}
+
+func (service *Service) findCleanURL(name string) string {
+	if !service.cleanURLs || name == "." || path.Ext(name) != "" {
+		return ""
+	}
+
+	htmlPath := name + ".html"
+	if stat, err := fs.Stat(service.fileSystem, htmlPath); err == nil && !stat.IsDir() {
+		return htmlPath
+	}
+
+	return ""
+}
+
+// cleanURLFor returns the clean form of a request path ending in ".html".
+func (service *Service) cleanURLFor(urlPath string) (string, bool) {
+	withoutExtension, found := strings.CutSuffix(urlPath, ".html")
+	if !found {
+		return "", false
+	}
+
+	// Index files are cleaned up to their directory
+	directory, file := path.Split(urlPath)
+	if slices.Contains(service.indexes, file) {
+		if service.trailingSlash == TrailingSlashStrip && directory != "/" {
+			directory = strings.TrimSuffix(directory, "/")
+		}
+
+		return directory, true
+	}
+
+	if strings.HasSuffix(withoutExtension, "/") {
+		return "", false
+	}
+
+	return withoutExtension, true
+}
+
+type rewriteContextKey struct{}
+
+// rewriteRequest returns a copy of the request for the given path that is
+// marked as an internal rewrite.
+func rewriteRequest(r *http.Request, urlPath string) *http.Request {
+	rewritten := r.Clone(context.WithValue(r.Context(), rewriteContextKey{}, true))
+	rewritten.URL.Path = urlPath
+	rewritten.URL.RawPath = ""
+
+	return rewritten
+}
+
+func isRewritten(r *http.Request) bool {
+	rewritten, _ := r.Context().Value(rewriteContextKey{}).(bool)
+
+	return rewritten
+}
+
+// redirect sends the client to the path while preserving the query string.
+// Paths are relative to the mount of the service.
+func redirect(w http.ResponseWriter, r *http.Request, location string, status int) {
+	location = mountedPath(r, location)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+
+	doNotCache(w)
+	http.Redirect(w, r, location, status)
+}
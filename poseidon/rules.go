@@ -0,0 +1,156 @@
+package poseidon
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	// {name} and {name...} in targets of ServeMux style patterns
+	wildcardPlaceholders = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?:\.\.\.)?\}`)
+	// ${name}, $name and $1 in targets of regular expressions
+	regexpPlaceholders = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}|\$([A-Za-z0-9_]+)`)
+)
+
+// WithRedirect redirects requests matching the pattern to the target with the
+// status, whether a file exists at the path or not. The query string of the
+// request is kept.
+//
+// Patterns follow http.ServeMux, such as "GET example.com/blog/{year}/{slug...}",
+// with wildcards available in the target as {name}. Patterns whose path starts
+// with ^ are regular expressions matched against the path, such as
+// "example.com^/(\d{4})/(.*)$", with groups available in the target as $1 or
+// ${name}.
+func WithRedirect(from string, to string, status int) ConfigFunc {
+	return func(service *Service) error {
+		if !isRedirectStatus(status) {
+			return fmt.Errorf("invalid redirect status: %d", status)
+		}
+
+		rule, err := compileRule(from, to)
+		if err != nil {
+			return err
+		}
+		rule.status = status
+		rule.force = true
+
+		service.redirectRules = append(service.redirectRules, rule)
+
+		return nil
+	}
+}
+
+// WithRewrite serves the target instead of paths matching the pattern, which
+// takes the same forms as for WithRedirect. Files at the path win over the
+// rewrite, so catch-all patterns don't hide assets. Rewrites are only applied
+// once, so they can't loop.
+func WithRewrite(from string, to string) ConfigFunc {
+	return func(service *Service) error {
+		if !strings.HasPrefix(to, "/") {
+			return fmt.Errorf("rewrite target must be a path: %s", to)
+		}
+
+		rule, err := compileRule(from, to)
+		if err != nil {
+			return err
+		}
+		rule.status = http.StatusOK
+
+		service.redirectRules = append(service.redirectRules, rule)
+
+		return nil
+	}
+}
+
+func compileRule(from string, to string) (redirectRule, error) {
+	rule := redirectRule{to: to, mergesQuery: true}
+
+	method, value := cutMethod(from)
+	if host, expression, found := strings.Cut(value, "^"); found && !strings.Contains(host, "/") {
+		pattern, err := regexp.Compile("^" + expression)
+		if err != nil {
+			return rule, err
+		}
+		rule.method = method
+		rule.host = host
+		rule.pattern = pattern
+		rule.placeholders = regexpPlaceholders
+
+		return rule, nil
+	}
+
+	method, host, pattern, err := compileServeMuxPattern(from)
+	if err != nil {
+		return rule, err
+	}
+	rule.method = method
+	rule.host = host
+	rule.pattern = pattern
+	rule.placeholders = wildcardPlaceholders
+
+	return rule, nil
+}
+
+// compileServeMuxPattern turns "[METHOD ][HOST]/[PATH]" patterns into a
+// regular expression matching like http.ServeMux.
+func compileServeMuxPattern(value string) (string, string, *regexp.Regexp, error) {
+	method, value := cutMethod(value)
+
+	slash := strings.Index(value, "/")
+	if slash < 0 {
+		return "", "", nil, fmt.Errorf("pattern must contain a path: %s", value)
+	}
+	host, urlPath := value[:slash], value[slash:]
+
+	expression := strings.Builder{}
+	expression.WriteString("^")
+
+	segments := strings.Split(urlPath[1:], "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		expression.WriteString("/")
+
+		switch {
+		case segment == "{$}" && last:
+			expression.WriteString("$")
+			return method, host, regexp.MustCompile(expression.String()), nil
+		case segment == "" && last:
+			// Trailing slashes match the whole subtree
+			expression.WriteString(".*$")
+			pattern, err := regexp.Compile(expression.String())
+			return method, host, pattern, err
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			name, rest, isRest := strings.Cut(strings.Trim(segment, "{}"), "...")
+			if wildcardPlaceholders.FindString(segment) != segment || (isRest && (!last || rest != "")) {
+				return "", "", nil, fmt.Errorf("invalid wildcard %s in pattern %s", segment, value)
+			}
+
+			if isRest {
+				expression.WriteString(`(?P<` + name + `>.*)`)
+			} else {
+				expression.WriteString(`(?P<` + name + `>[^/]+)`)
+			}
+		case strings.ContainsAny(segment, "{}"):
+			return "", "", nil, fmt.Errorf("wildcards must be full segments: %s", value)
+		default:
+			expression.WriteString(regexp.QuoteMeta(segment))
+		}
+	}
+
+	expression.WriteString("$")
+	pattern, err := regexp.Compile(expression.String())
+
+	return method, host, pattern, err
+}
+
+// cutMethod splits the method off "[METHOD ]PATTERN".
+func cutMethod(value string) (string, string) {
+	before, after, found := strings.Cut(value, " ")
+	if !found || before == "" || strings.ToUpper(before) != before || strings.ContainsAny(before, "/^") {
+		return "", value
+	}
+
+	return before, strings.TrimLeft(after, " ")
+}
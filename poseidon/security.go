@@ -0,0 +1,377 @@
+package poseidon
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecurityHeaders describes the security headers of responses. Empty fields
+// are left out, and headers set while handling the request, for example by
+// an upstream or a _headers file, are kept.
+type SecurityHeaders struct {
+	// HSTSMaxAge enables Strict-Transport-Security when positive
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// FrameOptions is DENY or SAMEORIGIN, which also sets the frame-ancestors
+	// of the Content-Security-Policy when it has none
+	FrameOptions              string
+	ContentTypeOptions        bool
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+	ContentSecurityPolicy     *ContentSecurityPolicy
+	// ReportOnly sends the policy as Content-Security-Policy-Report-Only, so
+	// violations are reported without being blocked
+	ReportOnly bool
+}
+
+// DefaultSecurityHeaders returns headers suited to most static sites: HSTS
+// for two years, no framing, no sniffing, no camera, microphone or location
+// access, and a policy restricting content to the own origin. Embedding
+// cross-origin content may need a looser policy.
+func DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		HSTSMaxAge:                2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		FrameOptions:              "DENY",
+		ContentTypeOptions:        true,
+		ReferrerPolicy:            "strict-origin-when-cross-origin",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+		ContentSecurityPolicy: &ContentSecurityPolicy{
+			DefaultSrc: []string{CSPSelf},
+			ImgSrc:     []string{CSPSelf, "data:"},
+			ObjectSrc:  []string{CSPNone},
+			BaseURI:    []string{CSPSelf},
+			FormAction: []string{CSPSelf},
+		},
+	}
+}
+
+// stylePreloadHandler is the onload attribute of the stylesheet preloads in
+// templates/index.go.html, which nonces don't cover
+const stylePreloadHandler = "this.onload=null;this.rel='stylesheet'"
+
+var stylePreloadHash = func() string {
+	hash := sha256.Sum256([]byte(stylePreloadHandler))
+
+	return "'sha256-" + base64.StdEncoding.EncodeToString(hash[:]) + "'"
+}()
+
+// Keywords of Content-Security-Policy source lists
+const (
+	CSPSelf          = "'self'"
+	CSPNone          = "'none'"
+	CSPUnsafeInline  = "'unsafe-inline'"
+	CSPUnsafeEval    = "'unsafe-eval'"
+	CSPStrictDynamic = "'strict-dynamic'"
+)
+
+// ContentSecurityPolicy describes a Content-Security-Policy header. Empty
+// source lists are left out.
+type ContentSecurityPolicy struct {
+	DefaultSrc     []string
+	ScriptSrc      []string
+	StyleSrc       []string
+	ImgSrc         []string
+	FontSrc        []string
+	ConnectSrc     []string
+	MediaSrc       []string
+	ObjectSrc      []string
+	FrameSrc       []string
+	WorkerSrc      []string
+	ManifestSrc    []string
+	BaseURI        []string
+	FormAction     []string
+	FrameAncestors []string
+	// UpgradeInsecureRequests makes browsers load http:// content over https
+	UpgradeInsecureRequests bool
+	ReportURI               string
+	ReportTo                string
+}
+
+type cspSourceList struct {
+	name    string
+	sources *[]string
+}
+
+func (policy *ContentSecurityPolicy) sourceLists() []cspSourceList {
+	return []cspSourceList{
+		{"default-src", &policy.DefaultSrc},
+		{"script-src", &policy.ScriptSrc},
+		{"style-src", &policy.StyleSrc},
+		{"img-src", &policy.ImgSrc},
+		{"font-src", &policy.FontSrc},
+		{"connect-src", &policy.ConnectSrc},
+		{"media-src", &policy.MediaSrc},
+		{"object-src", &policy.ObjectSrc},
+		{"frame-src", &policy.FrameSrc},
+		{"worker-src", &policy.WorkerSrc},
+		{"manifest-src", &policy.ManifestSrc},
+		{"base-uri", &policy.BaseURI},
+		{"form-action", &policy.FormAction},
+		{"frame-ancestors", &policy.FrameAncestors},
+	}
+}
+
+// String formats the policy as a Content-Security-Policy header value.
+func (policy ContentSecurityPolicy) String() string {
+	return policy.withNonce("")
+}
+
+// withNonce formats the policy, allowing scripts and styles with the nonce
+// when their sources are restricted.
+func (policy ContentSecurityPolicy) withNonce(nonce string) string {
+	if nonce != "" {
+		// Without their own list, scripts and styles fall back to default-src
+		for _, list := range []*[]string{&policy.ScriptSrc, &policy.StyleSrc} {
+			sources := *list
+			if len(sources) == 0 {
+				sources = policy.DefaultSrc
+			}
+			if len(sources) > 0 {
+				*list = append(append([]string{}, sources...), "'nonce-"+nonce+"'")
+			}
+		}
+
+		if len(policy.ScriptSrc) > 0 {
+			policy.ScriptSrc = append(policy.ScriptSrc, "'unsafe-hashes'", stylePreloadHash)
+		}
+	}
+
+	directives := []string{}
+	for _, list := range policy.sourceLists() {
+		if len(*list.sources) > 0 {
+			directives = append(directives, list.name+" "+strings.Join(*list.sources, " "))
+		}
+	}
+	if policy.UpgradeInsecureRequests {
+		directives = append(directives, "upgrade-insecure-requests")
+	}
+	if policy.ReportURI != "" {
+		directives = append(directives, "report-uri "+policy.ReportURI)
+	}
+	if policy.ReportTo != "" {
+		directives = append(directives, "report-to "+policy.ReportTo)
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+// ParseContentSecurityPolicy reads a policy from Content-Security-Policy
+// directives, for example "default-src 'self'; img-src 'self' data:".
+func ParseContentSecurityPolicy(value string) (ContentSecurityPolicy, error) {
+	policy := ContentSecurityPolicy{}
+	lists := policy.sourceLists()
+
+	for _, directive := range strings.Split(value, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		name, sources := strings.ToLower(fields[0]), fields[1:]
+
+		switch name {
+		case "upgrade-insecure-requests":
+			policy.UpgradeInsecureRequests = true
+			continue
+		case "report-uri", "report-to":
+			if len(sources) != 1 {
+				return ContentSecurityPolicy{}, fmt.Errorf("invalid content security directive: %s", strings.TrimSpace(directive))
+			}
+			if name == "report-uri" {
+				policy.ReportURI = sources[0]
+			} else {
+				policy.ReportTo = sources[0]
+			}
+			continue
+		}
+
+		found := false
+		for _, list := range lists {
+			if list.name == name {
+				*list.sources = append(*list.sources, sources...)
+				found = true
+			}
+		}
+		if !found {
+			return ContentSecurityPolicy{}, fmt.Errorf("unknown content security directive: %s", name)
+		}
+	}
+
+	return policy, nil
+}
+
+// WithSecurityHeaders sets the security headers on every response. HTML
+// rendered by WithClientSideRoutingAndServerSideRendering gets a fresh nonce
+// per request on its script, style and link tags, which the policy allows
+// along with the onload handler that applies its preloaded stylesheets.
+func WithSecurityHeaders(headers SecurityHeaders) ConfigFunc {
+	return func(service *Service) error {
+		switch strings.ToUpper(headers.FrameOptions) {
+		case "", "DENY", "SAMEORIGIN":
+		default:
+			return fmt.Errorf("invalid frame options: %s", headers.FrameOptions)
+		}
+		service.securityHeaders = &headers
+
+		return WithMiddleware(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				state := &securityState{enabled: headers.ContentSecurityPolicy != nil}
+
+				next.ServeHTTP(
+					&securityResponseWriter{ResponseWriter: w, headers: headers, state: state},
+					r.WithContext(context.WithValue(r.Context(), securityContextKey{}, state)),
+				)
+			})
+		})(service)
+	}
+}
+
+type securityContextKey struct{}
+
+// securityState carries the nonce of a response, created when a page asks
+// for one.
+type securityState struct {
+	enabled bool
+	nonce   string
+}
+
+// requestNonce returns the nonce of the response to the request, or an empty
+// string without a Content-Security-Policy.
+func requestNonce(r *http.Request) string {
+	state, _ := r.Context().Value(securityContextKey{}).(*securityState)
+	if state == nil || !state.enabled {
+		return ""
+	}
+
+	if state.nonce == "" {
+		nonce := make([]byte, 16)
+		_, _ = rand.Read(nonce)
+		state.nonce = base64.RawURLEncoding.EncodeToString(nonce)
+	}
+
+	return state.nonce
+}
+
+// securityResponseWriter adds the security headers missing right before the
+// response starts, once a page had the chance to ask for a nonce.
+type securityResponseWriter struct {
+	http.ResponseWriter
+	headers     SecurityHeaders
+	state       *securityState
+	wroteHeader bool
+}
+
+func (w *securityResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.headers.apply(w.Header(), w.state.nonce)
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *securityResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *securityResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *securityResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (headers SecurityHeaders) apply(header http.Header, nonce string) {
+	setDefault := func(key string, value string) {
+		if value != "" && header.Get(key) == "" {
+			header.Set(key, value)
+		}
+	}
+
+	if headers.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(headers.HSTSMaxAge/time.Second), 10)
+		if headers.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if headers.HSTSPreload {
+			hsts += "; preload"
+		}
+		setDefault("Strict-Transport-Security", hsts)
+	}
+
+	frameOptions := strings.ToUpper(headers.FrameOptions)
+	setDefault("X-Frame-Options", frameOptions)
+	if headers.ContentTypeOptions {
+		setDefault("X-Content-Type-Options", "nosniff")
+	}
+	setDefault("Referrer-Policy", headers.ReferrerPolicy)
+	setDefault("Permissions-Policy", headers.PermissionsPolicy)
+	setDefault("Cross-Origin-Opener-Policy", headers.CrossOriginOpenerPolicy)
+	setDefault("Cross-Origin-Embedder-Policy", headers.CrossOriginEmbedderPolicy)
+	setDefault("Cross-Origin-Resource-Policy", headers.CrossOriginResourcePolicy)
+
+	if headers.ContentSecurityPolicy != nil {
+		policy := *headers.ContentSecurityPolicy
+		if len(policy.FrameAncestors) == 0 {
+			switch frameOptions {
+			case "DENY":
+				policy.FrameAncestors = []string{CSPNone}
+			case "SAMEORIGIN":
+				policy.FrameAncestors = []string{CSPSelf}
+			}
+		}
+
+		name := "Content-Security-Policy"
+		if headers.ReportOnly {
+			name = "Content-Security-Policy-Report-Only"
+		}
+		setDefault(name, policy.withNonce(nonce))
+	}
+}
+
+var (
+	nonceTagPattern       = regexp.MustCompile(`(?i)<(script|style|link)\b[^>]*`)
+	nonceAttributePattern = regexp.MustCompile(`(?i)\snonce\s*=`)
+)
+
+// addNonce puts the nonce on the script, style and link tags of the HTML
+// that don't have one yet.
+func addNonce(html template.HTML, nonce string) template.HTML {
+	if nonce == "" {
+		return html
+	}
+
+	return template.HTML(nonceTagPattern.ReplaceAllStringFunc(string(html), func(tag string) string {
+		if nonceAttributePattern.MatchString(tag) {
+			return tag
+		}
+
+		name := nonceTagPattern.FindStringSubmatch(tag)[1]
+
+		return "<" + name + ` nonce="` + nonce + `"` + tag[len(name)+1:]
+	}))
+}
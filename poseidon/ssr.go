@@ -29,6 +29,14 @@ type SSRPayload struct {
 	HeaderContent template.HTML
 }
 
+// ssrTemplateData is what index.go.html renders, with asset paths below the
+// mount of the service and the nonce of the Content-Security-Policy.
+type ssrTemplateData struct {
+	SSRPayload
+	BasePath string
+	Nonce    string
+}
+
 type viteManifest map[string]viteManifestEntry
 
 type viteManifestEntry struct {
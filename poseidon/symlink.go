@@ -0,0 +1,267 @@
+package poseidon
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type SymlinkPolicy string
+
+const (
+	// SymlinkFollow follows all symlinks, even those pointing outside the root
+	SymlinkFollow SymlinkPolicy = "follow"
+	// SymlinkWithinRoot only follows symlinks that resolve inside the root
+	SymlinkWithinRoot SymlinkPolicy = "within-root"
+	// SymlinkDeny refuses any path that goes through a symlink
+	SymlinkDeny SymlinkPolicy = "deny"
+)
+
+// maxSymlinkHops mirrors the limit most operating systems use for ELOOP
+const maxSymlinkHops = 40
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// LinkFS is a file system that can inspect symlinks. It matches the method
+// set of fs.ReadLinkFS, which os.DirFS implements from Go 1.25 on.
+type LinkFS interface {
+	fs.FS
+	Lstat(name string) (fs.FileInfo, error)
+	ReadLink(name string) (string, error)
+}
+
+// DirFS returns a file system for the directory like os.DirFS that can also
+// inspect symlinks, as required by WithSymlinkPolicy.
+func DirFS(dir string) fs.FS {
+	return &dirFS{FS: os.DirFS(dir), dir: dir}
+}
+
+type dirFS struct {
+	fs.FS
+	dir string
+
+	mutex sync.Mutex
+	root  *os.Root
+}
+
+// rootedFS is backed by a directory, whose os.Root keeps symlinks swapped in
+// after the check from leaving it.
+type rootedFS interface {
+	openRoot() (*os.Root, error)
+	withinRoot(target string) (string, bool)
+}
+
+func (fileSystem *dirFS) openRoot() (*os.Root, error) {
+	fileSystem.mutex.Lock()
+	defer fileSystem.mutex.Unlock()
+
+	if fileSystem.root == nil {
+		root, err := os.OpenRoot(fileSystem.dir)
+		if err != nil {
+			return nil, err
+		}
+		fileSystem.root = root
+	}
+
+	return fileSystem.root, nil
+}
+
+// withinRoot maps an absolute symlink target inside the directory to a path
+// relative to it.
+func (fileSystem *dirFS) withinRoot(target string) (string, bool) {
+	dir, err := filepath.Abs(fileSystem.dir)
+	if err != nil {
+		return "", false
+	}
+
+	dirs := []string{dir}
+	if evaluated, err := filepath.EvalSymlinks(dir); err == nil && evaluated != dir {
+		dirs = append(dirs, evaluated)
+	}
+
+	for _, dir := range dirs {
+		relative, err := filepath.Rel(dir, target)
+		if err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(relative), true
+		}
+	}
+
+	return "", false
+}
+
+func (fileSystem *dirFS) join(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return filepath.Join(fileSystem.dir, filepath.FromSlash(name)), nil
+}
+
+func (fileSystem *dirFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(fileSystem.FS, name)
+}
+
+func (fileSystem *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(fileSystem.FS, name)
+}
+
+func (fileSystem *dirFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(fileSystem.FS, name)
+}
+
+func (fileSystem *dirFS) Lstat(name string) (fs.FileInfo, error) {
+	fullPath, err := fileSystem.join("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Lstat(fullPath)
+}
+
+func (fileSystem *dirFS) ReadLink(name string) (string, error) {
+	fullPath, err := fileSystem.join("readlink", name)
+	if err != nil {
+		return "", err
+	}
+
+	return os.Readlink(fullPath)
+}
+
+// WithSymlinkPolicy controls how symlinks inside the served file system are
+// handled. Policies other than SymlinkFollow need a file system implementing
+// LinkFS, such as the one returned by DirFS.
+func WithSymlinkPolicy(policy SymlinkPolicy) ConfigFunc {
+	return func(service *Service) error {
+		switch policy {
+		case SymlinkFollow:
+			return nil
+		case SymlinkWithinRoot, SymlinkDeny:
+		default:
+			return fmt.Errorf("unknown symlink policy: %s", policy)
+		}
+
+		linkFS, ok := service.fileSystem.(LinkFS)
+		if !ok {
+			return fmt.Errorf("symlink policy %s requires a file system that can inspect symlinks", policy)
+		}
+
+		service.fileSystem = symlinkFS{fileSystem: linkFS, policy: policy}
+
+		return nil
+	}
+}
+
+type symlinkFS struct {
+	fileSystem LinkFS
+	policy     SymlinkPolicy
+}
+
+func (fileSystem symlinkFS) Open(name string) (fs.File, error) {
+	resolved, err := fileSystem.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if rooted, ok := fileSystem.fileSystem.(rootedFS); ok {
+		root, err := rooted.openRoot()
+		if err != nil {
+			return nil, err
+		}
+
+		return root.Open(filepath.FromSlash(resolved))
+	}
+
+	return fileSystem.fileSystem.Open(resolved)
+}
+
+func (fileSystem symlinkFS) Stat(name string) (fs.FileInfo, error) {
+	resolved, err := fileSystem.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	if rooted, ok := fileSystem.fileSystem.(rootedFS); ok {
+		root, err := rooted.openRoot()
+		if err != nil {
+			return nil, err
+		}
+
+		return root.Stat(filepath.FromSlash(resolved))
+	}
+
+	return fs.Stat(fileSystem.fileSystem, resolved)
+}
+
+// resolve replaces the symlinks of the path by their targets, refusing them
+// according to the policy.
+func (fileSystem symlinkFS) resolve(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", fs.ErrInvalid
+	}
+
+	pending := strings.Split(name, "/")
+	resolved := "."
+	hops := 0
+	for len(pending) > 0 {
+		current := path.Join(resolved, pending[0])
+		pending = pending[1:]
+
+		info, err := fileSystem.fileSystem.Lstat(current)
+		if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = current
+			continue
+		}
+
+		if fileSystem.policy == SymlinkDeny {
+			return "", fs.ErrNotExist
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", errTooManySymlinks
+		}
+
+		target, err := fileSystem.fileSystem.ReadLink(current)
+		if err != nil {
+			return "", err
+		}
+
+		var joined string
+		switch {
+		case filepath.IsAbs(target):
+			// Absolute targets only count when they point inside the root
+			rooted, ok := fileSystem.fileSystem.(rootedFS)
+			if !ok {
+				return "", fs.ErrNotExist
+			}
+
+			if joined, ok = rooted.withinRoot(target); !ok {
+				return "", fs.ErrNotExist
+			}
+		case path.IsAbs(filepath.ToSlash(target)):
+			return "", fs.ErrNotExist
+		default:
+			joined = path.Join(resolved, filepath.ToSlash(target))
+		}
+
+		// Targets climbing above the root escape it
+		if joined == ".." || strings.HasPrefix(joined, "../") {
+			return "", fs.ErrNotExist
+		}
+
+		// Resolve the target from the root again as it may contain symlinks
+		pending = append(strings.Split(joined, "/"), pending...)
+		resolved = "."
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,160 @@
+package poseidon
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostLabelPattern accepts DNS labels, so they are safe in directory names
+var hostLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// hostBuildFailureTTL is how long labels that failed to build are handled
+// like unknown hosts before they are built again
+const hostBuildFailureTTL = 10 * time.Second
+
+// HostRouter dispatches requests to handlers, usually services, by their
+// Host header. Hosts are matched exactly first, then by wildcard, and
+// requests for other hosts go to the default handler.
+type HostRouter struct {
+	hosts          map[string]http.Handler
+	wildcards      []*hostWildcard
+	defaultHandler http.Handler
+}
+
+// hostWildcard builds a handler for each label matching "*.suffix".
+type hostWildcard struct {
+	suffix string
+	build  func(label string) (http.Handler, error)
+
+	mutex  sync.Mutex
+	builds map[string]*hostBuild
+}
+
+// hostBuild holds the handler of a label once done is closed, or nil if the
+// build failed.
+type hostBuild struct {
+	done    chan struct{}
+	handler http.Handler
+}
+
+// NewHostRouter creates a router without hosts, answering every request
+// with a 404 until hosts are added.
+func NewHostRouter() *HostRouter {
+	return &HostRouter{
+		hosts: map[string]http.Handler{},
+	}
+}
+
+// Handle routes requests for the host, such as "example.com", to the
+// handler. The port of requests is ignored.
+func (router *HostRouter) Handle(host string, handler http.Handler) {
+	router.hosts[normalizeHost(host)] = handler
+}
+
+// HandleWildcard routes requests for hosts matching a pattern such as
+// "*.preview.example.com" to the handler built for the label in place of
+// the asterisk ("pr-42" for "pr-42.preview.example.com"). Handlers are built
+// on the first request for a label and reused. Labels that fail to build are
+// handled like unknown hosts for a few seconds, and only DNS labels reach
+// build, so they can safely name directories.
+func (router *HostRouter) HandleWildcard(pattern string, build func(label string) (http.Handler, error)) error {
+	suffix, found := strings.CutPrefix(normalizeHost(pattern), "*.")
+	if !found || strings.Contains(suffix, "*") {
+		return fmt.Errorf("invalid wildcard host: %s", pattern)
+	}
+
+	router.wildcards = append(router.wildcards, &hostWildcard{
+		suffix: suffix,
+		build:  build,
+		builds: map[string]*hostBuild{},
+	})
+
+	// Longest suffixes are matched first
+	slices.SortStableFunc(router.wildcards, func(a *hostWildcard, b *hostWildcard) int {
+		return len(b.suffix) - len(a.suffix)
+	})
+
+	return nil
+}
+
+// HandleDefault routes requests for hosts without a handler of their own.
+func (router *HostRouter) HandleDefault(handler http.Handler) {
+	router.defaultHandler = handler
+}
+
+func (router *HostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler := router.handler(normalizeHost(r.Host)); handler != nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	if router.defaultHandler != nil {
+		router.defaultHandler.ServeHTTP(w, r)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func (router *HostRouter) handler(host string) http.Handler {
+	if handler, found := router.hosts[host]; found {
+		return handler
+	}
+
+	for _, wildcard := range router.wildcards {
+		label, found := strings.CutSuffix(host, "."+wildcard.suffix)
+		if !found || !hostLabelPattern.MatchString(label) {
+			continue
+		}
+
+		return wildcard.handler(label)
+	}
+
+	return nil
+}
+
+// handler builds the handler of the label outside the lock, with requests
+// for the same label waiting for the first one.
+func (wildcard *hostWildcard) handler(label string) http.Handler {
+	wildcard.mutex.Lock()
+	if build, found := wildcard.builds[label]; found {
+		wildcard.mutex.Unlock()
+		<-build.done
+
+		return build.handler
+	}
+
+	build := &hostBuild{done: make(chan struct{})}
+	wildcard.builds[label] = build
+	wildcard.mutex.Unlock()
+
+	defer func() {
+		if build.handler == nil {
+			time.AfterFunc(hostBuildFailureTTL, func() {
+				wildcard.mutex.Lock()
+				defer wildcard.mutex.Unlock()
+
+				if wildcard.builds[label] == build {
+					delete(wildcard.builds, label)
+				}
+			})
+		}
+		close(build.done)
+	}()
+
+	if handler, err := wildcard.build(label); err == nil {
+		build.handler = handler
+	}
+
+	return build.handler
+}
+
+// normalizeHost lowercases the host and drops the port and the trailing dot.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(hostWithoutPort(host)), ".")
+}